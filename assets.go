@@ -0,0 +1,273 @@
+package spider
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// AssetType is the kind of resource an Asset points to.
+type AssetType int
+
+const (
+	// AssetOther is a resource whose type is unknown, an <embed> without extension for instance.
+	AssetOther AssetType = iota
+	AssetImage
+	AssetScript
+	AssetStylesheet
+	AssetVideo
+	AssetAudio
+	AssetFont
+	AssetDocument
+)
+
+func (t AssetType) String() string {
+	switch t {
+	case AssetImage:
+		return "image"
+	case AssetScript:
+		return "script"
+	case AssetStylesheet:
+		return "stylesheet"
+	case AssetVideo:
+		return "video"
+	case AssetAudio:
+		return "audio"
+	case AssetFont:
+		return "font"
+	case AssetDocument:
+		return "document"
+	}
+	return "other"
+}
+
+// assetExtensions classifies the links and CSS urls by the extension of their path.
+var assetExtensions = map[string]AssetType{
+	".jpg": AssetImage, ".jpeg": AssetImage, ".png": AssetImage, ".gif": AssetImage, ".webp": AssetImage,
+	".svg": AssetImage, ".avif": AssetImage, ".ico": AssetImage, ".bmp": AssetImage,
+	".js": AssetScript, ".mjs": AssetScript,
+	".css": AssetStylesheet,
+	".mp4": AssetVideo, ".webm": AssetVideo, ".mov": AssetVideo, ".m3u8": AssetVideo, ".mkv": AssetVideo,
+	".mp3": AssetAudio, ".ogg": AssetAudio, ".wav": AssetAudio, ".flac": AssetAudio, ".m4a": AssetAudio,
+	".woff": AssetFont, ".woff2": AssetFont, ".ttf": AssetFont, ".otf": AssetFont, ".eot": AssetFont,
+	".pdf": AssetDocument, ".doc": AssetDocument, ".docx": AssetDocument, ".xls": AssetDocument,
+	".xlsx": AssetDocument, ".ppt": AssetDocument, ".pptx": AssetDocument, ".odt": AssetDocument,
+	".csv": AssetDocument, ".epub": AssetDocument, ".zip": AssetDocument, ".gz": AssetDocument,
+	".tar": AssetDocument, ".7z": AssetDocument, ".rar": AssetDocument,
+}
+
+// preloadTypes classifies <link rel="preload"> by their as attribute.
+var preloadTypes = map[string]AssetType{
+	"image":  AssetImage,
+	"script": AssetScript,
+	"style":  AssetStylesheet,
+	"video":  AssetVideo,
+	"audio":  AssetAudio,
+	"font":   AssetFont,
+}
+
+var cssURL = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"]*))\s*\)`)
+
+// Asset is a resource referenced by an HTML page.
+type Asset struct {
+	// URL is absolute, without fragment.
+	URL  string
+	Type AssetType
+	// Tag and Attr tell where the URL has been found. Attr is empty for the content of a <style> element.
+	Tag  string
+	Attr string
+}
+
+// AssetFilter keeps the assets for which it returns true.
+type AssetFilter func(Asset) bool
+
+// AssetsOfType keeps the assets of the provided types.
+func AssetsOfType(types ...AssetType) AssetFilter {
+	return func(a Asset) bool {
+		for _, t := range types {
+			if a.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AssetsOnHost keeps the assets hosted on one of the provided hosts, compared without port.
+func AssetsOnHost(hosts ...string) AssetFilter {
+	return func(a Asset) bool {
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			return false
+		}
+		for _, h := range hosts {
+			if strings.EqualFold(u.Hostname(), h) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Assets returns the images, scripts, stylesheets, videos, audios, fonts and documents
+// referenced by the HTML response of this context, kept by all the filters.
+//
+// The URLs are resolved against the BaseURL of the context.
+// The body of the response can still be read afterwards.
+func (c *Context) Assets(filters ...AssetFilter) ([]Asset, error) {
+	if err := c.rewindResponse(); err != nil {
+		return nil, err
+	}
+	doc, err := c.HTMLParser()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.rewindResponse(); err != nil {
+		return nil, err
+	}
+	return ExtractAssets(doc, c.effectiveURL(), filters...), nil
+}
+
+// ExtractAssets returns the assets referenced by doc, resolved against its <base> or base, kept by all the filters.
+// Each URL is returned once per type.
+func ExtractAssets(doc *goquery.Document, base *url.URL, filters ...AssetFilter) []Asset {
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = u
+		}
+	}
+	x := &assetExtractor{base: base, filters: filters, seen: make(map[Asset]bool)}
+
+	doc.Find("img").Each(func(_ int, s *goquery.Selection) {
+		x.attr(s, "img", "src", AssetImage)
+		x.attr(s, "img", "data-src", AssetImage)
+		x.srcset(s, "img", "srcset", AssetImage)
+		x.srcset(s, "img", "data-srcset", AssetImage)
+	})
+	doc.Find("source").Each(func(_ int, s *goquery.Selection) {
+		t := AssetImage
+		switch goquery.NodeName(s.Parent()) {
+		case "video":
+			t = AssetVideo
+		case "audio":
+			t = AssetAudio
+		}
+		x.attr(s, "source", "src", t)
+		x.srcset(s, "source", "srcset", t)
+	})
+	doc.Find("video").Each(func(_ int, s *goquery.Selection) {
+		x.attr(s, "video", "src", AssetVideo)
+		x.attr(s, "video", "poster", AssetImage)
+	})
+	doc.Find("audio").Each(func(_ int, s *goquery.Selection) {
+		x.attr(s, "audio", "src", AssetAudio)
+	})
+	doc.Find("script[src]").Each(func(_ int, s *goquery.Selection) {
+		x.attr(s, "script", "src", AssetScript)
+	})
+	doc.Find("link[href]").Each(func(_ int, s *goquery.Selection) {
+		rels := strings.Fields(strings.ToLower(s.AttrOr("rel", "")))
+		for _, rel := range rels {
+			switch rel {
+			case "stylesheet":
+				x.attr(s, "link", "href", AssetStylesheet)
+			case "icon", "apple-touch-icon":
+				x.attr(s, "link", "href", AssetImage)
+			case "preload", "prefetch":
+				if t, ok := preloadTypes[strings.ToLower(s.AttrOr("as", ""))]; ok {
+					x.attr(s, "link", "href", t)
+				}
+			}
+		}
+	})
+	doc.Find("embed[src]").Each(func(_ int, s *goquery.Selection) {
+		x.attr(s, "embed", "src", x.typeOf(s.AttrOr("src", ""), AssetOther))
+	})
+	doc.Find("object[data]").Each(func(_ int, s *goquery.Selection) {
+		x.attr(s, "object", "data", x.typeOf(s.AttrOr("data", ""), AssetOther))
+	})
+	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		if t := x.typeOf(s.AttrOr("href", ""), AssetOther); t != AssetOther {
+			x.attr(s, "a", "href", t)
+		}
+	})
+	doc.Find("[style]").Each(func(_ int, s *goquery.Selection) {
+		x.css(s.AttrOr("style", ""), goquery.NodeName(s), "style")
+	})
+	doc.Find("style").Each(func(_ int, s *goquery.Selection) {
+		x.css(s.Text(), "style", "")
+	})
+	return x.assets
+}
+
+type assetExtractor struct {
+	base    *url.URL
+	filters []AssetFilter
+	seen    map[Asset]bool
+	assets  []Asset
+}
+
+func (x *assetExtractor) attr(s *goquery.Selection, tag, attr string, t AssetType) {
+	if v, ok := s.Attr(attr); ok {
+		x.add(v, tag, attr, t)
+	}
+}
+
+// srcset adds the URLs of the candidates of a srcset attribute, "url [descriptor], ...".
+func (x *assetExtractor) srcset(s *goquery.Selection, tag, attr string, t AssetType) {
+	v, ok := s.Attr(attr)
+	if !ok {
+		return
+	}
+	for _, candidate := range strings.Split(v, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			x.add(fields[0], tag, attr, t)
+		}
+	}
+}
+
+// css adds the url() of a stylesheet or a style attribute, images unless their extension tells otherwise.
+func (x *assetExtractor) css(css, tag, attr string) {
+	for _, m := range cssURL.FindAllStringSubmatch(css, -1) {
+		raw := m[1] + m[2] + m[3]
+		x.add(raw, tag, attr, x.typeOf(raw, AssetImage))
+	}
+}
+
+func (x *assetExtractor) typeOf(raw string, def AssetType) AssetType {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return def
+	}
+	if t, ok := assetExtensions[strings.ToLower(path.Ext(u.Path))]; ok {
+		return t
+	}
+	return def
+}
+
+func (x *assetExtractor) add(raw, tag, attr string, t AssetType) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "#") {
+		return
+	}
+	u, err := x.base.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+	u.Fragment = ""
+	a := Asset{URL: u.String(), Type: t, Tag: tag, Attr: attr}
+	key := Asset{URL: a.URL, Type: t}
+	if x.seen[key] {
+		return
+	}
+	for _, keep := range x.filters {
+		if !keep(a) {
+			return
+		}
+	}
+	x.seen[key] = true
+	x.assets = append(x.assets, a)
+}
@@ -0,0 +1,79 @@
+package spider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const assetsFixture = `<html><head>
+<link rel="stylesheet" href="/css/site.css">
+<link rel="icon" href="favicon.ico">
+<link rel="preload" as="font" href="/fonts/a.woff2">
+<script src="app.js"></script>
+<style>body { background: url("/img/bg.png"); } @font-face { src: url(/fonts/b.woff); }</style>
+</head><body>
+<img src="logo.png" srcset="logo-2x.png 2x, https://cdn.example.com/logo-3x.png 3x">
+<img src="data:image/png;base64,AAAA">
+<picture><source srcset="hero.webp 1x"></picture>
+<video poster="poster.jpg"><source src="movie.mp4" type="video/mp4"></video>
+<div style="background-image: url('/img/card.jpg')"></div>
+<a href="/files/report.pdf#page=2">Report</a>
+<a href="/about">About</a>
+<img src="logo.png">
+</body></html>`
+
+func TestAssets(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, assetsFixture)
+	}))
+	defer ts.Close()
+
+	ctx, err := NewHTTPContext("GET", ts.URL+"/page/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.DoRequest(); err != nil {
+		t.Fatal(err)
+	}
+	assets, err := ctx.Assets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]AssetType{
+		ts.URL + "/css/site.css":              AssetStylesheet,
+		ts.URL + "/page/favicon.ico":          AssetImage,
+		ts.URL + "/fonts/a.woff2":             AssetFont,
+		ts.URL + "/page/app.js":               AssetScript,
+		ts.URL + "/img/bg.png":                AssetImage,
+		ts.URL + "/fonts/b.woff":              AssetFont,
+		ts.URL + "/page/logo.png":             AssetImage,
+		ts.URL + "/page/logo-2x.png":          AssetImage,
+		"https://cdn.example.com/logo-3x.png": AssetImage,
+		ts.URL + "/page/hero.webp":            AssetImage,
+		ts.URL + "/page/poster.jpg":           AssetImage,
+		ts.URL + "/page/movie.mp4":            AssetVideo,
+		ts.URL + "/img/card.jpg":              AssetImage,
+		ts.URL + "/files/report.pdf":          AssetDocument,
+	}
+	if len(assets) != len(expected) {
+		t.Errorf("Expected %d assets but got %d: %v", len(expected), len(assets), assets)
+	}
+	for _, a := range assets {
+		if typ, ok := expected[a.URL]; !ok || typ != a.Type {
+			t.Errorf("Unexpected asset %+v", a)
+		}
+	}
+
+	images, _ := ctx.Assets(AssetsOfType(AssetImage), AssetsOnHost("cdn.example.com"))
+	if len(images) != 1 || images[0].URL != "https://cdn.example.com/logo-3x.png" || images[0].Attr != "srcset" {
+		t.Errorf("Expected the filters to keep the CDN image, got %v", images)
+	}
+
+	body, _ := ioutil.ReadAll(ctx.Response().Body)
+	if string(body) != assetsFixture {
+		t.Error("Expected the body to still be readable")
+	}
+}
@@ -0,0 +1,162 @@
+package spider
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Body produces the body of a request.
+//
+// Reader is called for every request created with it and every time the request is sent again,
+// so that each Setup and each retry gets a fresh body.
+type Body interface {
+	Reader() (io.Reader, error)
+	// ContentType returns the Content-Type of the body, or an empty string to keep the one of the request.
+	ContentType() string
+}
+
+type bytesBody struct {
+	data        []byte
+	contentType string
+}
+
+func (b *bytesBody) Reader() (io.Reader, error) { return bytes.NewReader(b.data), nil }
+func (b *bytesBody) ContentType() string        { return b.contentType }
+
+// BytesBody returns a Body sending data with the provided Content-Type.
+func BytesBody(data []byte, contentType string) Body {
+	return &bytesBody{data: data, contentType: contentType}
+}
+
+type funcBody struct {
+	fn          func() (io.Reader, error)
+	contentType string
+}
+
+func (b *funcBody) Reader() (io.Reader, error) { return b.fn() }
+func (b *funcBody) ContentType() string        { return b.contentType }
+
+// BodyFunc returns a Body calling fn for a new reader each time one is needed.
+func BodyFunc(fn func() (io.Reader, error), contentType string) Body {
+	return &funcBody{fn: fn, contentType: contentType}
+}
+
+// JSONBody returns a Body sending v encoded in JSON.
+// v is encoded each time a reader is needed, so changes made to it are sent by the next requests.
+func JSONBody(v interface{}) Body {
+	return BodyFunc(func() (io.Reader, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}, "application/json")
+}
+
+// FormBody returns a Body sending values encoded as application/x-www-form-urlencoded.
+func FormBody(values url.Values) Body {
+	return BodyFunc(func() (io.Reader, error) {
+		return strings.NewReader(values.Encode()), nil
+	}, "application/x-www-form-urlencoded")
+}
+
+// MultipartFile is a file sent in a multipart body.
+type MultipartFile struct {
+	Field    string
+	Filename string
+	// Open returns the content of the file. It is called for each request.
+	Open func() (io.Reader, error)
+}
+
+type multipartBody struct {
+	fields   map[string]string
+	files    []MultipartFile
+	boundary string
+}
+
+// MultipartBody returns a Body sending the provided fields and files as multipart/form-data.
+func MultipartBody(fields map[string]string, files ...MultipartFile) Body {
+	return &multipartBody{
+		fields:   fields,
+		files:    files,
+		boundary: multipart.NewWriter(ioutil.Discard).Boundary(),
+	}
+}
+
+func (b *multipartBody) Reader() (io.Reader, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.SetBoundary(b.boundary); err != nil {
+		return nil, err
+	}
+	for name, value := range b.fields {
+		if err := w.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range b.files {
+		part, err := w.CreateFormFile(f.Field, f.Filename)
+		if err != nil {
+			return nil, err
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(part, r)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func (b *multipartBody) ContentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+// readerBody buffers a reader the first time it is needed so that it can be read again.
+type readerBody struct {
+	once sync.Once
+	r    io.Reader
+	data []byte
+	err  error
+}
+
+func (b *readerBody) Reader() (io.Reader, error) {
+	b.once.Do(func() {
+		b.data, b.err = ioutil.ReadAll(b.r)
+	})
+	return bytes.NewReader(b.data), b.err
+}
+
+func (b *readerBody) ContentType() string { return "" }
+
+// ReaderBody returns a Body reading r entirely the first time it is needed
+// and sending a copy of its content for every request.
+func ReaderBody(r io.Reader) Body {
+	return &readerBody{r: r}
+}
+
+// WithBody sets the body of the request, replacing the one passed to NewHTTPContext.
+// The Content-Type of the body, if any, replaces the one of the request.
+func WithBody(body Body) Option {
+	return func(c *config) {
+		c.body = body
+		if ct := body.ContentType(); ct != "" {
+			c.contentType = ct
+		}
+	}
+}
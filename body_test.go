@@ -0,0 +1,133 @@
+package spider
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyReplayedAcrossRuns(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+	}))
+	defer ts.Close()
+
+	s := Post(ts.URL, strings.NewReader("payload"), func(ctx *Context) error {
+		_, err := ctx.DoRequest()
+		return err
+	})
+	for i := 0; i < 2; i++ {
+		ctx, err := s.Setup(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Spin(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(bodies) != 2 || bodies[0] != "payload" || bodies[1] != "payload" {
+		t.Errorf("Expected the body to be sent by every run, got %q", bodies)
+	}
+}
+
+func TestBodyNotInherited(t *testing.T) {
+	var bodies, contentTypes []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+	}))
+	defer ts.Close()
+
+	child := Get(ts.URL, func(ctx *Context) error {
+		_, err := ctx.DoRequest()
+		return err
+	})
+	parent := Post(ts.URL, nil, func(ctx *Context) error {
+		if _, err := ctx.DoRequest(); err != nil {
+			return err
+		}
+		childCtx, err := child.Setup(ctx)
+		if err != nil {
+			return err
+		}
+		return child.Spin(childCtx)
+	})
+	parent.body = BytesBody([]byte("payload"), "text/plain")
+	ctx, err := parent.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parent.Spin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 || bodies[0] != "payload" || contentTypes[0] != "text/plain" {
+		t.Fatalf("Expected the parent to send its body, got %q %q", bodies, contentTypes)
+	}
+	if bodies[1] != "" || contentTypes[1] == "text/plain" {
+		t.Errorf("Expected the child to send no body, got %q with Content-Type %q", bodies[1], contentTypes[1])
+	}
+}
+
+func TestJSONBody(t *testing.T) {
+	v := map[string]int{"page": 1}
+	ctx, err := NewHTTPContext("POST", "http://example.com", nil, WithBody(JSONBody(v)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := ctx.Request()
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected a JSON Content-Type but got %q", ct)
+	}
+	for i := 0; i < 2; i++ {
+		r, err := req.GetBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		if string(data) != `{"page":1}` {
+			t.Errorf("Unexpected body %q", data)
+		}
+	}
+}
+
+func TestMultipartBody(t *testing.T) {
+	var field, file string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		field = r.FormValue("name")
+		f, _, err := r.FormFile("upload")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := ioutil.ReadAll(f)
+		file = string(data)
+	}))
+	defer ts.Close()
+
+	body := MultipartBody(map[string]string{"name": "spider"}, MultipartFile{
+		Field:    "upload",
+		Filename: "data.txt",
+		Open:     func() (io.Reader, error) { return strings.NewReader("content"), nil },
+	})
+	ctx, err := NewHTTPContext("POST", ts.URL, nil, WithBody(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || field != "spider" || file != "content" {
+		t.Errorf("Unexpected multipart request: status %d, field %q, file %q", res.StatusCode, field, file)
+	}
+}
@@ -0,0 +1,189 @@
+package spider
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = errors.New("Circuit breaker is open")
+)
+
+// CircuitState is the state of a circuit of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails fast with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial request through after the cooldown.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// CircuitBreaker stops sending requests to a failing target.
+//
+// It keeps a circuit per key, a host or an entry name. A circuit opens after Threshold consecutive failures
+// and fails fast with ErrCircuitOpen. After Cooldown, it half-opens and lets a single trial through:
+// the circuit closes if it succeeds and opens again if it fails.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	// IsFailure classifies the outcome of a request made through the Transport of the breaker.
+	// By default, errors and 5xx responses are failures.
+	IsFailure func(*http.Response, error) bool
+
+	mu        sync.Mutex
+	circuits  map[string]*circuit
+	listeners []func(key string, from, to CircuitState)
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+// Notify registers a function called when a circuit changes state.
+func (cb *CircuitBreaker) Notify(fn func(key string, from, to CircuitState)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.listeners = append(cb.listeners, fn)
+}
+
+// State returns the state of the circuit of key.
+func (cb *CircuitBreaker) State(key string) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if c, ok := cb.circuits[key]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+func (cb *CircuitBreaker) circuit(key string) *circuit {
+	c, ok := cb.circuits[key]
+	if !ok {
+		c = &circuit{}
+		cb.circuits[key] = c
+	}
+	return c
+}
+
+// Allow returns ErrCircuitOpen if a request for key must not be made.
+// Every allowed request must be followed by a call to Report.
+func (cb *CircuitBreaker) Allow(key string) error {
+	cb.mu.Lock()
+	c := cb.circuit(key)
+	var changed bool
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.openedAt) < cb.Cooldown {
+			cb.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		c.state = CircuitHalfOpen
+		c.trial = true
+		changed = true
+	case CircuitHalfOpen:
+		if c.trial {
+			cb.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		c.trial = true
+	}
+	listeners := cb.listeners
+	cb.mu.Unlock()
+
+	if changed {
+		notify(listeners, key, CircuitOpen, CircuitHalfOpen)
+	}
+	return nil
+}
+
+// Report records the outcome of a request for key.
+func (cb *CircuitBreaker) Report(key string, failed bool) {
+	cb.mu.Lock()
+	c := cb.circuit(key)
+	from := c.state
+	c.trial = false
+	if failed {
+		c.failures++
+		if c.state == CircuitHalfOpen || (c.state == CircuitClosed && c.failures >= cb.Threshold) {
+			c.state = CircuitOpen
+			c.openedAt = time.Now()
+		}
+	} else {
+		c.failures = 0
+		c.state = CircuitClosed
+	}
+	to := c.state
+	listeners := cb.listeners
+	cb.mu.Unlock()
+
+	if from != to {
+		notify(listeners, key, from, to)
+	}
+}
+
+func notify(listeners []func(string, CircuitState, CircuitState), key string, from, to CircuitState) {
+	for _, fn := range listeners {
+		fn(key, from, to)
+	}
+}
+
+func (cb *CircuitBreaker) isFailure(res *http.Response, err error) bool {
+	if cb.IsFailure != nil {
+		return cb.IsFailure(res, err)
+	}
+	return err != nil || res.StatusCode >= 500
+}
+
+// Transport returns an http.RoundTripper keeping a circuit per host for the requests made with base.
+func (cb *CircuitBreaker) Transport(base http.RoundTripper) http.RoundTripper {
+	return &breakerTransport{breaker: cb, base: base}
+}
+
+type breakerTransport struct {
+	breaker *CircuitBreaker
+	base    http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.Host
+	if err := t.breaker.Allow(key); err != nil {
+		return nil, err
+	}
+	res, err := t.base.RoundTrip(req)
+	t.breaker.Report(key, t.breaker.isFailure(res, err))
+	return res, err
+}
+
+// WithCircuitBreaker sends the requests through the provided CircuitBreaker, with a circuit per host.
+func WithCircuitBreaker(cb *CircuitBreaker) Option {
+	return func(c *config) {
+		c.breaker = cb
+	}
+}
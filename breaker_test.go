@@ -0,0 +1,159 @@
+package spider
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+func TestCircuitBreakerPerHost(t *testing.T) {
+	var requests int
+	status := http.StatusInternalServerError
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	cb := NewCircuitBreaker(2, 50*time.Millisecond)
+	var transitions []CircuitState
+	cb.Notify(func(key string, from, to CircuitState) {
+		transitions = append(transitions, to)
+	})
+	do := func() error {
+		ctx, err := NewHTTPContext("GET", ts.URL, nil, WithCircuitBreaker(cb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ctx.DoRequest()
+		return err
+	}
+
+	do()
+	do()
+	if err := do(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen but got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the open circuit to fail fast, got %d requests", requests)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	status = http.StatusOK
+	if err := do(); err != nil {
+		t.Errorf("Expected the trial request to go through, got %v", err)
+	}
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Expected transitions %v but got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("Expected transitions %v but got %v", expected, transitions)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	cb := NewCircuitBreaker(1, 0)
+	cb.Report("host", true)
+	if cb.State("host") != CircuitOpen {
+		t.Fatal("Expected the circuit to be open")
+	}
+	if err := cb.Allow("host"); err != nil {
+		t.Fatalf("Expected a trial after the cooldown, got %v", err)
+	}
+	if err := cb.Allow("host"); err != ErrCircuitOpen {
+		t.Errorf("Expected a single trial in half-open state, got %v", err)
+	}
+	cb.Report("host", true)
+	if cb.State("host") != CircuitOpen {
+		t.Errorf("Expected a failed trial to open the circuit, got %s", cb.State("host"))
+	}
+}
+
+func TestCircuitBreakerPerEntry(t *testing.T) {
+	s := &flakySpider{failures: 10}
+	cb := NewCircuitBreaker(2, time.Hour)
+	sched := NewScheduler()
+	var events []Event
+	sched.OnEvent(func(ev Event) {
+		events = append(events, ev)
+	})
+	e := &Entry{Name: "flaky", Spider: s, Breaker: cb}
+	sched.AddEntry(e)
+
+	for i := 0; i < 4; i++ {
+		sched.runEntry(e, nil)
+	}
+	if s.runs != 2 {
+		t.Errorf("Expected the entry to be skipped once the circuit is open, got %d runs", s.runs)
+	}
+	history := e.History()
+	if history[3].Err != ErrCircuitOpen {
+		t.Errorf("Expected the skipped run to fail with ErrCircuitOpen, got %v", history[3].Err)
+	}
+
+	var circuitEvents int
+	for _, ev := range events {
+		if ev.Type == EventCircuit {
+			circuitEvents++
+			if ev.Circuit != "flaky" || ev.To != CircuitOpen {
+				t.Errorf("Unexpected circuit event: %+v", ev)
+			}
+		}
+	}
+	if circuitEvents != 1 {
+		t.Errorf("Expected a single circuit event but got %d", circuitEvents)
+	}
+}
+
+func TestCircuitBreakerUnnamedEntries(t *testing.T) {
+	cb := NewCircuitBreaker(1, time.Hour)
+	sched := NewScheduler()
+	flaky := &Entry{Spider: &flakySpider{failures: 10}, Breaker: cb}
+	healthy := &flakySpider{}
+	other := &Entry{Spider: healthy, Breaker: cb}
+	sched.AddEntry(flaky)
+	sched.AddEntry(other)
+
+	sched.runEntry(flaky, nil)
+	sched.runEntry(other, nil)
+	if healthy.runs != 1 {
+		t.Errorf("Expected unnamed entries to have their own circuit, got %d runs", healthy.runs)
+	}
+}
+
+func TestCircuitBreakerWrappedErrorNotRetried(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	cb := NewCircuitBreaker(1, time.Hour)
+	var runs int
+	s := Get(ts.URL, func(ctx *Context) error {
+		runs++
+		res, err := ctx.DoRequest()
+		if err == nil && res.StatusCode >= 500 {
+			err = errors.New(res.Status)
+		}
+		return err
+	}, WithCircuitBreaker(cb))
+	e := &Entry{Spider: s, Retry: &RunRetry{
+		MaxAttempts: 5,
+		NewBackOff:  func() backoff.BackOff { return backoff.NewConstantBackOff(time.Millisecond) },
+	}}
+	sched := NewScheduler()
+	sched.AddEntry(e)
+	sched.runEntry(e, nil)
+	if runs != 2 || requests != 1 {
+		t.Errorf("Expected no retry once the circuit is open, got %d runs and %d requests", runs, requests)
+	}
+}
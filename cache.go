@@ -0,0 +1,396 @@
+package spider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// XFromCache is the header set on responses served from a Cache.
+const XFromCache = "X-From-Cache"
+
+// Cache stores serialized HTTP responses.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryCache is a Cache keeping the responses in memory.
+type MemoryCache struct {
+	mu    sync.RWMutex
+	items map[string][]byte
+}
+
+// NewMemoryCache returns a new MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		items: make(map[string][]byte),
+	}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.items[key]
+	return value, ok
+}
+
+func (m *MemoryCache) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = value
+}
+
+func (m *MemoryCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+}
+
+// DiskCache is a Cache keeping the responses in files of a directory.
+type DiskCache struct {
+	Dir string
+}
+
+// NewDiskCache returns a new DiskCache storing its files in dir.
+// The directory is created if it does not exist.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{Dir: dir}, nil
+}
+
+func (d *DiskCache) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(d.filename(key))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (d *DiskCache) Set(key string, value []byte) {
+	filename := d.filename(key)
+	f, err := ioutil.TempFile(d.Dir, "tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	os.Rename(f.Name(), filename)
+}
+
+func (d *DiskCache) Delete(key string) {
+	os.Remove(d.filename(key))
+}
+
+// cachedResponse is the serialized form of a response in a Cache.
+type cachedResponse struct {
+	StatusCode int               `json:"status"`
+	Header     http.Header       `json:"header"`
+	Body       []byte            `json:"body"`
+	StoredAt   time.Time         `json:"storedAt"`
+	Vary       map[string]string `json:"vary,omitempty"`
+}
+
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	header := cloneHeader(c.Header)
+	header.Set(XFromCache, "1")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.StatusCode, http.StatusText(c.StatusCode)),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// varyMatches reports whether the request has the same values as the stored one for the Vary headers.
+func (c *cachedResponse) varyMatches(req *http.Request) bool {
+	for name, value := range c.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh reports whether the response can be served without validation, following RFC 7234 section 4.2.
+func (c *cachedResponse) fresh(now time.Time) bool {
+	cc := parseCacheControl(c.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	date, err := http.ParseTime(c.Header.Get("Date"))
+	if err != nil {
+		date = c.StoredAt
+	}
+
+	var lifetime time.Duration
+	if maxAge, ok := cc["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil {
+			return false
+		}
+		lifetime = time.Duration(seconds) * time.Second
+	} else if expires := c.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return false
+		}
+		lifetime = t.Sub(date)
+	} else if lastModified, err := http.ParseTime(c.Header.Get("Last-Modified")); err == nil {
+		// Heuristic freshness: 10% of the time since the last modification.
+		lifetime = date.Sub(lastModified) / 10
+	}
+
+	age := now.Sub(c.StoredAt)
+	if seconds, err := strconv.Atoi(c.Header.Get("Age")); err == nil {
+		age += time.Duration(seconds) * time.Second
+	}
+	return lifetime > age
+}
+
+// parseCacheControl parses the Cache-Control directives of a header.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, value := range h["Cache-Control"] {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if i := strings.IndexByte(part, '='); i >= 0 {
+				cc[strings.ToLower(part[:i])] = strings.Trim(part[i+1:], `"`)
+			} else {
+				cc[strings.ToLower(part)] = ""
+			}
+		}
+	}
+	return cc
+}
+
+func cacheKey(method, url string) string {
+	return method + " " + url
+}
+
+// storable reports whether a response can be stored, following RFC 7234 section 3.
+func storable(req *http.Request, res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+	cc := parseCacheControl(res.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	// A shared cache does not store private responses, nor the responses to authenticated requests
+	// unless they are explicitly allowed, following RFC 7234 section 3.2.
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		_, mustRevalidate := cc["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+	// An event stream never ends, it cannot be read to be stored.
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+	for _, vary := range res.Header["Vary"] {
+		if strings.TrimSpace(vary) == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheTransport returns an http.RoundTripper caching the responses of base in c.
+//
+// Fresh responses are served from the cache. Stale responses with an ETag or a Last-Modified header
+// are revalidated with If-None-Match and If-Modified-Since, and served from the cache on 304 Not Modified.
+// Responses served from the cache have the XFromCache header set.
+// Successful POST, PUT, PATCH and DELETE requests invalidate the cached responses of their url.
+func CacheTransport(c Cache, base http.RoundTripper) http.RoundTripper {
+	return &cacheTransport{cache: c, base: base}
+}
+
+type cacheTransport struct {
+	cache Cache
+	base  http.RoundTripper
+}
+
+type noCacheKey struct{}
+
+// withoutCache returns a context whose requests bypass the cache transport.
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if bypass, _ := req.Context().Value(noCacheKey{}).(bool); bypass {
+		return t.base.RoundTrip(req)
+	}
+	url := req.URL.String()
+	switch req.Method {
+	case "GET", "HEAD":
+	case "POST", "PUT", "PATCH", "DELETE":
+		res, err := t.base.RoundTrip(req)
+		if err == nil && res.StatusCode < 400 {
+			t.cache.Delete(cacheKey("GET", url))
+			t.cache.Delete(cacheKey("HEAD", url))
+		}
+		return res, err
+	default:
+		return t.base.RoundTrip(req)
+	}
+	if req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return t.base.RoundTrip(req)
+	}
+
+	key := cacheKey(req.Method, url)
+	cached := t.load(key, req)
+	validating := false
+	if cached != nil {
+		_, noCache := reqCC["no-cache"]
+		if !noCache && reqCC["max-age"] != "0" && cached.fresh(time.Now()) {
+			return cached.response(req), nil
+		}
+		etag, lastModified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if (etag != "" || lastModified != "") && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+			req = req.Clone(req.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				req.Header.Set("If-Modified-Since", lastModified)
+			}
+			validating = true
+		}
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if validating && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		for k, v := range res.Header {
+			switch k {
+			case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Set-Cookie", "Set-Cookie2":
+				continue
+			}
+			cached.Header[k] = v
+		}
+		cached.StoredAt = time.Now()
+		t.store(key, cached)
+		validated := cached.response(req)
+		// The cookies are set once by this response, they are not replayed by the next hits.
+		for _, k := range []string{"Set-Cookie", "Set-Cookie2"} {
+			if v, ok := res.Header[k]; ok {
+				validated.Header[k] = v
+			}
+		}
+		return validated, nil
+	}
+	if !storable(req, res) {
+		return res, nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	entry := &cachedResponse{
+		StatusCode: res.StatusCode,
+		Header:     cloneHeader(res.Header),
+		Body:       body,
+		StoredAt:   time.Now(),
+	}
+	// The cookies are set by this response only, they are not replayed by the hits.
+	entry.Header.Del("Set-Cookie")
+	entry.Header.Del("Set-Cookie2")
+	for _, vary := range res.Header["Vary"] {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if entry.Vary == nil {
+				entry.Vary = make(map[string]string)
+			}
+			entry.Vary[name] = req.Header.Get(name)
+		}
+	}
+	t.store(key, entry)
+	return res, nil
+}
+
+func (t *cacheTransport) load(key string, req *http.Request) *cachedResponse {
+	data, ok := t.cache.Get(key)
+	if !ok {
+		return nil
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil || !cached.varyMatches(req) {
+		return nil
+	}
+	return &cached
+}
+
+func (t *cacheTransport) store(key string, cached *cachedResponse) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	t.cache.Set(key, data)
+}
+
+// WithCache caches the responses of the http.Client in the provided Cache.
+func WithCache(c Cache) Option {
+	return func(cfg *config) {
+		cfg.cache = c
+	}
+}
+
+// FromCache reports whether the response of this context has been served from a Cache.
+// A Spin can use it to skip the processing of unchanged content.
+func (c *Context) FromCache() bool {
+	return c.response != nil && c.response.Header.Get(XFromCache) == "1"
+}
@@ -0,0 +1,157 @@
+package spider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func fetch(t *testing.T, c Cache, url string) (*Context, string) {
+	ctx, err := NewHTTPContext("GET", url, nil, WithCache(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.DoRequest(); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ctx.RAWContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, string(body)
+}
+
+func TestCacheConditionalRequests(t *testing.T) {
+	var requests, notModified int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "spider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []Cache{NewMemoryCache(), disk} {
+		requests, notModified = 0, 0
+		ctx, body := fetch(t, c, ts.URL)
+		if ctx.FromCache() || body != "content" {
+			t.Errorf("First response should not come from the cache: %q", body)
+		}
+		ctx, body = fetch(t, c, ts.URL)
+		if !ctx.FromCache() || body != "content" {
+			t.Errorf("Second response should be served from the cache: %q", body)
+		}
+		if requests != 2 || notModified != 1 {
+			t.Errorf("Expected a conditional request, got %d requests and %d not modified", requests, notModified)
+		}
+	}
+}
+
+func TestCacheFreshResponse(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("fresh"))
+	}))
+	defer ts.Close()
+
+	c := NewMemoryCache()
+	fetch(t, c, ts.URL)
+	ctx, body := fetch(t, c, ts.URL)
+	if !ctx.FromCache() || body != "fresh" {
+		t.Errorf("Expected the fresh response to be served from the cache: %q", body)
+	}
+	if requests != 1 {
+		t.Errorf("Expected a single request but got %d", requests)
+	}
+
+	post, _ := NewHTTPContext("POST", ts.URL, nil, WithCache(c))
+	if _, err := post.DoRequest(); err != nil {
+		t.Fatal(err)
+	}
+	if ctx, _ := fetch(t, c, ts.URL); ctx.FromCache() {
+		t.Error("A POST should invalidate the cached response")
+	}
+}
+
+func TestCacheNoStore(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+	}))
+	defer ts.Close()
+
+	c := NewMemoryCache()
+	fetch(t, c, ts.URL)
+	if ctx, _ := fetch(t, c, ts.URL); ctx.FromCache() {
+		t.Error("A no-store response should not be cached")
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests but got %d", requests)
+	}
+}
+
+func TestCacheEventStreamNotStorable(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/events", nil)
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}}
+	if storable(req, res) {
+		t.Error("Expected an event stream not to be storable")
+	}
+}
+
+func TestCachePrivateAndAuthorizedNotStorable(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	private := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"private, max-age=60"}}}
+	if storable(req, private) {
+		t.Error("Expected a private response not to be storable")
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}}}
+	if storable(req, res) {
+		t.Error("Expected the response to an authorized request not to be storable")
+	}
+	res.Header.Set("Cache-Control", "public, max-age=60")
+	if !storable(req, res) {
+		t.Error("Expected a public response to an authorized request to be storable")
+	}
+}
+
+func TestCacheDoesNotStoreCookies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.SetCookie(w, &http.Cookie{Name: "id", Value: "1"})
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	c := NewMemoryCache()
+	if ctx, _ := fetch(t, c, ts.URL); len(ctx.Response().Cookies()) != 1 {
+		t.Error("Expected the first response to set its cookie")
+	}
+	ctx, _ := fetch(t, c, ts.URL)
+	if !ctx.FromCache() {
+		t.Fatal("Expected the second response to be served from the cache")
+	}
+	if cookies := ctx.Response().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no cookie in the cached response, got %v", cookies)
+	}
+}
@@ -0,0 +1,547 @@
+package spider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+var (
+	ErrNoBrowser  = errors.New("No Chrome or Chromium executable found")
+	ErrNoDevTools = errors.New("Browser did not print its DevTools URL")
+	ErrCDPClosed  = errors.New("DevTools connection closed")
+)
+
+// DefaultMaxTabs is the number of pages a CDPRenderer renders at the same time when MaxTabs is zero.
+const DefaultMaxTabs = 4
+
+// browserNames are the executables looked up in PATH when CDPRenderer.ExecPath is empty.
+var browserNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+// CDPRenderer is a Renderer driving a headless Chrome through the Chrome DevTools Protocol.
+//
+// A single browser is launched on the first Render and shared by every rendering,
+// each page being loaded in its own tab. At most MaxTabs pages are rendered at the same time.
+// Close stops the browser; it is launched again by the next Render.
+type CDPRenderer struct {
+	// URL is the DevTools websocket URL of an already running browser.
+	// If it is set, no browser is launched.
+	URL string
+	// ExecPath is the path of the browser executable, looked up in PATH if empty.
+	ExecPath string
+	// Flags are added to the command line of the browser, --no-sandbox for instance.
+	Flags   []string
+	MaxTabs int
+
+	mu   sync.Mutex
+	conn *cdpConn
+	cmd  *exec.Cmd
+	dir  string
+	tabs chan struct{}
+}
+
+// NewCDPRenderer returns a new CDPRenderer launching a headless browser when needed.
+func NewCDPRenderer(maxTabs int) *CDPRenderer {
+	return &CDPRenderer{MaxTabs: maxTabs}
+}
+
+// Render loads the request in a new tab and returns the page once wait is satisfied.
+// Each tab has its own browser context, disposed of when it is closed,
+// so the cookies and the storage of a page are not seen by the others.
+//
+// The cookies of the request are set in the browser for the URL of the request only.
+// Its other headers are sent with every request of the tab, the subresources included,
+// so Authorization and Proxy-Authorization are left out.
+func (r *CDPRenderer) Render(req *http.Request, wait Wait) (*RenderedPage, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), wait.timeout())
+	defer cancel()
+
+	conn, tabs, err := r.connect()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case tabs <- struct{}{}:
+		defer func() { <-tabs }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var browserContext struct {
+		BrowserContextID string `json:"browserContextId"`
+	}
+	if err := conn.call(ctx, "", "Target.createBrowserContext", map[string]interface{}{}, &browserContext); err != nil {
+		return nil, err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn.call(ctx, "", "Target.disposeBrowserContext", map[string]interface{}{"browserContextId": browserContext.BrowserContextID}, nil)
+	}()
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	params := map[string]interface{}{"url": "about:blank", "browserContextId": browserContext.BrowserContextID}
+	if err := conn.call(ctx, "", "Target.createTarget", params, &target); err != nil {
+		return nil, err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn.call(ctx, "", "Target.closeTarget", map[string]interface{}{"targetId": target.TargetID}, nil)
+	}()
+
+	var attached struct {
+		SessionID string `json:"sessionId"`
+	}
+	err = conn.call(ctx, "", "Target.attachToTarget", map[string]interface{}{"targetId": target.TargetID, "flatten": true}, &attached)
+	if err != nil {
+		return nil, err
+	}
+	tab := &cdpTab{conn: conn, session: attached.SessionID}
+	events := conn.subscribe(tab.session)
+	defer conn.unsubscribe(tab.session)
+
+	if err := tab.prepare(ctx, req); err != nil {
+		return nil, err
+	}
+	page, err := tab.navigate(ctx, req.URL.String(), wait, events)
+	if err != nil {
+		return nil, err
+	}
+	if wait.Selector != "" {
+		if err := tab.waitSelector(ctx, wait.Selector); err != nil {
+			return nil, err
+		}
+	}
+	if err := tab.evaluate(ctx, "document.documentElement.outerHTML", &page.HTML); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Close closes the connection to the browser and stops it if it has been launched by the renderer.
+func (r *CDPRenderer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		r.conn.close()
+		r.conn = nil
+	}
+	var err error
+	if r.cmd != nil {
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+		r.cmd = nil
+		err = os.RemoveAll(r.dir)
+	}
+	return err
+}
+
+// connect returns the connection to the browser, launching it if needed.
+func (r *CDPRenderer) connect() (*cdpConn, chan struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tabs == nil {
+		max := r.MaxTabs
+		if max <= 0 {
+			max = DefaultMaxTabs
+		}
+		r.tabs = make(chan struct{}, max)
+	}
+	if r.conn != nil && !r.conn.isClosed() {
+		return r.conn, r.tabs, nil
+	}
+
+	url := r.URL
+	if url == "" {
+		var err error
+		if url, err = r.launch(); err != nil {
+			return nil, nil, err
+		}
+	}
+	conn, err := dialCDP(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.conn = conn
+	return conn, r.tabs, nil
+}
+
+// launch starts a headless browser and returns its DevTools URL.
+func (r *CDPRenderer) launch() (string, error) {
+	if r.cmd != nil {
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+		os.RemoveAll(r.dir)
+		r.cmd = nil
+	}
+	path := r.ExecPath
+	if path == "" {
+		for _, name := range browserNames {
+			if p, err := exec.LookPath(name); err == nil {
+				path = p
+				break
+			}
+		}
+		if path == "" {
+			return "", ErrNoBrowser
+		}
+	}
+	dir, err := ioutil.TempDir("", "spider-chrome")
+	if err != nil {
+		return "", err
+	}
+	args := append([]string{
+		"--headless",
+		"--disable-gpu",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--remote-debugging-port=0",
+		"--user-data-dir=" + dir,
+	}, r.Flags...)
+	cmd := exec.Command(path, append(args, "about:blank")...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "DevTools listening on ") {
+				found <- strings.TrimPrefix(line, "DevTools listening on ")
+				break
+			}
+		}
+		close(found)
+		// Keep reading so that the browser never blocks on a full pipe.
+		ioutil.ReadAll(stderr)
+	}()
+	select {
+	case url, ok := <-found:
+		if ok {
+			r.cmd, r.dir = cmd, dir
+			return url, nil
+		}
+	case <-time.After(20 * time.Second):
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	os.RemoveAll(dir)
+	return "", ErrNoDevTools
+}
+
+// cdpTab is a tab of the browser attached with a flat session.
+type cdpTab struct {
+	conn    *cdpConn
+	session string
+}
+
+func (t *cdpTab) call(ctx context.Context, method string, params, result interface{}) error {
+	return t.conn.call(ctx, t.session, method, params, result)
+}
+
+// prepare enables the events of the tab and sets the cookies and the headers of the request.
+func (t *cdpTab) prepare(ctx context.Context, req *http.Request) error {
+	if err := t.call(ctx, "Page.enable", nil, nil); err != nil {
+		return err
+	}
+	if err := t.call(ctx, "Page.setLifecycleEventsEnabled", map[string]interface{}{"enabled": true}, nil); err != nil {
+		return err
+	}
+	if err := t.call(ctx, "Network.enable", nil, nil); err != nil {
+		return err
+	}
+	var cookies []map[string]interface{}
+	for _, c := range req.Cookies() {
+		cookies = append(cookies, map[string]interface{}{"name": c.Name, "value": c.Value, "url": req.URL.String()})
+	}
+	if len(cookies) > 0 {
+		if err := t.call(ctx, "Network.setCookies", map[string]interface{}{"cookies": cookies}, nil); err != nil {
+			return err
+		}
+	}
+	headers := make(map[string]string)
+	for k, v := range req.Header {
+		if k == "User-Agent" || k == "Cookie" || k == "Authorization" || k == "Proxy-Authorization" {
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+	if len(headers) > 0 {
+		if err := t.call(ctx, "Network.setExtraHTTPHeaders", map[string]interface{}{"headers": headers}, nil); err != nil {
+			return err
+		}
+	}
+	if ua := req.Header.Get("User-Agent"); ua != "" {
+		return t.call(ctx, "Network.setUserAgentOverride", map[string]interface{}{"userAgent": ua}, nil)
+	}
+	return nil
+}
+
+// navigate loads url and waits for the load event and, if requested, the network to be idle.
+// It returns the page with the status, the URL and the headers of the response of the main document.
+func (t *cdpTab) navigate(ctx context.Context, url string, wait Wait, events <-chan cdpMessage) (*RenderedPage, error) {
+	var nav struct {
+		LoaderID  string `json:"loaderId"`
+		ErrorText string `json:"errorText"`
+	}
+	if err := t.call(ctx, "Page.navigate", map[string]interface{}{"url": url}, &nav); err != nil {
+		return nil, err
+	}
+	if nav.ErrorText != "" {
+		return nil, fmt.Errorf("Navigation to %s failed: %s", url, nav.ErrorText)
+	}
+
+	page := &RenderedPage{URL: url}
+	loaded, idle := false, !wait.NetworkIdle
+	for !loaded || !idle {
+		select {
+		case ev := <-events:
+			switch ev.Method {
+			case "Network.responseReceived":
+				var received struct {
+					LoaderID string `json:"loaderId"`
+					Type     string `json:"type"`
+					Response struct {
+						URL     string            `json:"url"`
+						Status  int               `json:"status"`
+						Headers map[string]string `json:"headers"`
+					} `json:"response"`
+				}
+				if err := json.Unmarshal(ev.Params, &received); err != nil || received.LoaderID != nav.LoaderID || received.Type != "Document" {
+					continue
+				}
+				page.URL = received.Response.URL
+				page.StatusCode = received.Response.Status
+				page.Header = make(http.Header, len(received.Response.Headers))
+				for k, v := range received.Response.Headers {
+					// Repeated headers are joined with newlines by the DevTools protocol.
+					page.Header[http.CanonicalHeaderKey(k)] = strings.Split(v, "\n")
+				}
+			case "Page.lifecycleEvent":
+				var lifecycle struct {
+					LoaderID string `json:"loaderId"`
+					Name     string `json:"name"`
+				}
+				if err := json.Unmarshal(ev.Params, &lifecycle); err != nil || lifecycle.LoaderID != nav.LoaderID {
+					continue
+				}
+				switch lifecycle.Name {
+				case "load":
+					loaded = true
+				case "networkIdle":
+					idle = true
+				}
+			}
+		case <-t.conn.closed:
+			return nil, ErrCDPClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return page, nil
+}
+
+// waitSelector polls the page until an element matches selector.
+func (t *cdpTab) waitSelector(ctx context.Context, selector string) error {
+	quoted, err := json.Marshal(selector)
+	if err != nil {
+		return err
+	}
+	expression := fmt.Sprintf("document.querySelector(%s) !== null", quoted)
+	for {
+		var found bool
+		if err := t.evaluate(ctx, expression, &found); err != nil {
+			return err
+		}
+		if found {
+			return nil
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// evaluate evaluates a JavaScript expression in the page and decodes its value into v.
+func (t *cdpTab) evaluate(ctx context.Context, expression string, v interface{}) error {
+	var result struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	params := map[string]interface{}{"expression": expression, "returnByValue": true}
+	if err := t.call(ctx, "Runtime.evaluate", params, &result); err != nil {
+		return err
+	}
+	if result.ExceptionDetails != nil {
+		return fmt.Errorf("Evaluation failed: %s", result.ExceptionDetails.Text)
+	}
+	return json.Unmarshal(result.Result.Value, v)
+}
+
+// cdpMessage is a command, a response or an event of the DevTools protocol.
+type cdpMessage struct {
+	ID        int64           `json:"id,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// cdpConn is a DevTools connection multiplexing the commands and the events of several sessions.
+type cdpConn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	nextID    int64
+	pending   map[int64]chan cdpMessage
+	listeners map[string]chan cdpMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func dialCDP(url string) (*cdpConn, error) {
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	conn := &cdpConn{
+		ws:        ws,
+		pending:   make(map[int64]chan cdpMessage),
+		listeners: make(map[string]chan cdpMessage),
+		closed:    make(chan struct{}),
+	}
+	go conn.read()
+	return conn, nil
+}
+
+func (c *cdpConn) read() {
+	defer c.close()
+	for {
+		var msg cdpMessage
+		if err := websocket.JSON.Receive(c.ws, &msg); err != nil {
+			return
+		}
+		c.mu.Lock()
+		if msg.ID != 0 {
+			if ch, ok := c.pending[msg.ID]; ok {
+				delete(c.pending, msg.ID)
+				ch <- msg
+			}
+		} else if ch, ok := c.listeners[msg.SessionID]; ok {
+			select {
+			case ch <- msg:
+			default:
+				// The tab is not waiting for events anymore.
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// call sends a command and decodes its result into result, if not nil.
+func (c *cdpConn) call(ctx context.Context, session, method string, params, result interface{}) error {
+	msg := cdpMessage{SessionID: session, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+	ch := make(chan cdpMessage, 1)
+	c.mu.Lock()
+	c.nextID++
+	msg.ID = c.nextID
+	c.pending[msg.ID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msg.ID)
+		c.mu.Unlock()
+	}()
+
+	c.writeMu.Lock()
+	err := websocket.JSON.Send(c.ws, msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case res := <-ch:
+		if res.Error != nil {
+			return fmt.Errorf("%s: %s", method, res.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(res.Result, result)
+		}
+		return nil
+	case <-c.closed:
+		return ErrCDPClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// subscribe returns a channel receiving the events of a session.
+func (c *cdpConn) subscribe(session string) <-chan cdpMessage {
+	ch := make(chan cdpMessage, 256)
+	c.mu.Lock()
+	c.listeners[session] = ch
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *cdpConn) unsubscribe(session string) {
+	c.mu.Lock()
+	delete(c.listeners, session)
+	c.mu.Unlock()
+}
+
+func (c *cdpConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *cdpConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.ws.Close()
+	})
+}
@@ -0,0 +1,199 @@
+package spider
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	ErrNoChangeDetection = errors.New("Change detection has not been enabled")
+)
+
+// Fingerprint is the hash of the monitored content of a page at a given time.
+// The content is kept to compute the lines that changed.
+type Fingerprint struct {
+	Hash    string    `json:"hash"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+}
+
+// Change describes how the monitored content of a page differs from the previous run.
+type Change struct {
+	Key      string
+	Changed  bool
+	First    bool
+	Previous Fingerprint
+	Current  Fingerprint
+	Added    []string
+	Removed  []string
+}
+
+// Summary returns a short description of the change.
+func (c *Change) Summary() string {
+	switch {
+	case c.First:
+		return "first fingerprint of " + c.Key
+	case !c.Changed:
+		return "no change in " + c.Key
+	}
+	return fmt.Sprintf("%s changed: +%d -%d lines", c.Key, len(c.Added), len(c.Removed))
+}
+
+// FingerprintStore keeps the last Fingerprint of each monitored page in a Cache.
+// A MemoryCache or a DiskCache can be used to keep them across restarts.
+type FingerprintStore struct {
+	cache Cache
+}
+
+// NewFingerprintStore returns a FingerprintStore backed by the provided Cache.
+func NewFingerprintStore(c Cache) *FingerprintStore {
+	return &FingerprintStore{cache: c}
+}
+
+func fingerprintKey(key string) string {
+	return "fingerprint " + key
+}
+
+// Get returns the last Fingerprint stored for key.
+func (s *FingerprintStore) Get(key string) (Fingerprint, bool) {
+	var fp Fingerprint
+	data, ok := s.cache.Get(fingerprintKey(key))
+	if !ok || json.Unmarshal(data, &fp) != nil {
+		return fp, false
+	}
+	return fp, true
+}
+
+// Set stores the Fingerprint of key.
+func (s *FingerprintStore) Set(key string, fp Fingerprint) {
+	data, err := json.Marshal(fp)
+	if err != nil {
+		return
+	}
+	s.cache.Set(fingerprintKey(key), data)
+}
+
+// Compare stores the Fingerprint of content for key and returns how it differs from the previous one.
+func (s *FingerprintStore) Compare(key, content string) *Change {
+	sum := sha256.Sum256([]byte(content))
+	current := Fingerprint{
+		Hash:    hex.EncodeToString(sum[:]),
+		Content: content,
+		Time:    time.Now(),
+	}
+	previous, ok := s.Get(key)
+	s.Set(key, current)
+
+	change := &Change{
+		Key:      key,
+		Changed:  !ok || previous.Hash != current.Hash,
+		First:    !ok,
+		Previous: previous,
+		Current:  current,
+	}
+	if ok && change.Changed {
+		change.Added, change.Removed = diffLines(previous.Content, current.Content)
+	}
+	return change
+}
+
+// diffLines returns the lines of b missing from a and the lines of a missing from b.
+func diffLines(a, b string) (added, removed []string) {
+	count := make(map[string]int)
+	for _, line := range strings.Split(a, "\n") {
+		count[line]++
+	}
+	for _, line := range strings.Split(b, "\n") {
+		if count[line] > 0 {
+			count[line]--
+			continue
+		}
+		added = append(added, line)
+	}
+	for _, line := range strings.Split(a, "\n") {
+		if count[line] > 0 {
+			count[line]--
+			removed = append(removed, line)
+		}
+	}
+	return added, removed
+}
+
+// changeDetector holds the change detection settings of a Context.
+type changeDetector struct {
+	store    *FingerprintStore
+	selector string
+}
+
+// content returns the monitored content of a body.
+func (d *changeDetector) content(body []byte) (string, error) {
+	if d.selector == "" {
+		return string(body), nil
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	doc.Find(d.selector).Each(func(_ int, s *goquery.Selection) {
+		parts = append(parts, strings.TrimSpace(s.Text()))
+	})
+	return strings.Join(parts, "\n"), nil
+}
+
+// WithChangeDetection keeps a Fingerprint of each response in the provided store, keyed by url.
+// If selector is not empty, only the text of the elements it matches is monitored.
+//
+// The body of the responses is buffered so that ctx.Changed can be called before or after parsing it.
+func WithChangeDetection(store *FingerprintStore, selector string) Option {
+	return func(c *config) {
+		c.changeDetector = &changeDetector{store: store, selector: selector}
+	}
+}
+
+// bufferBody reads the body of the response so that change detection can use it
+// and replaces it so that it can still be parsed.
+func (c *Context) bufferBody(res *http.Response) error {
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	c.body = body
+	c.change = nil
+	return err
+}
+
+// Changed reports how the monitored content of the response differs from the one of the previous run.
+//
+// The first call after a request stores the new Fingerprint; later calls return the same Change.
+// Change detection must be enabled with WithChangeDetection.
+func (c *Context) Changed() (*Change, error) {
+	if c.changeDetector == nil {
+		return nil, ErrNoChangeDetection
+	}
+	if c.change != nil {
+		return c.change, nil
+	}
+	if c.Response() == nil || c.Request() == nil {
+		return nil, ErrNoRequest
+	}
+	content, err := c.changeDetector.content(c.body)
+	if err != nil {
+		return nil, err
+	}
+	key := c.Request().URL.String()
+	if c.changeDetector.selector != "" {
+		key += " " + c.changeDetector.selector
+	}
+	c.change = c.changeDetector.store.Compare(key, content)
+	return c.change, nil
+}
@@ -0,0 +1,86 @@
+package spider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChanged(t *testing.T) {
+	page := `<html><div id="price">10</div><div id="ad">1</div></html>`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer ts.Close()
+
+	store := NewFingerprintStore(NewMemoryCache())
+	run := func() *Change {
+		ctx, err := NewHTTPContext("GET", ts.URL, nil, WithChangeDetection(store, "#price"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ctx.DoRequest(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ctx.HTMLParser(); err != nil {
+			t.Fatal(err)
+		}
+		change, err := ctx.Changed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return change
+	}
+
+	if change := run(); !change.First || !change.Changed {
+		t.Errorf("Expected the first run to be a change: %s", change.Summary())
+	}
+	page = `<html><div id="price">10</div><div id="ad">2</div></html>`
+	if change := run(); change.Changed {
+		t.Errorf("Changes outside of the selector should be ignored: %s", change.Summary())
+	}
+	page = `<html><div id="price">12</div><div id="ad">2</div></html>`
+	change := run()
+	if !change.Changed || change.First {
+		t.Fatalf("Expected a change: %s", change.Summary())
+	}
+	if len(change.Added) != 1 || change.Added[0] != "12" || len(change.Removed) != 1 || change.Removed[0] != "10" {
+		t.Errorf("Unexpected diff: +%v -%v", change.Added, change.Removed)
+	}
+}
+
+func TestChangedWithoutDetection(t *testing.T) {
+	ctx := NewContext()
+	if _, err := ctx.Changed(); err != ErrNoChangeDetection {
+		t.Errorf("Expected ErrNoChangeDetection but got %v", err)
+	}
+}
+
+func TestSchedulerOnChange(t *testing.T) {
+	body := "a"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	var changes []*Change
+	sched := NewScheduler(WithChangeDetection(NewFingerprintStore(NewMemoryCache()), ""))
+	sched.OnChange(func(e *Entry, ctx *Context, change *Change) {
+		changes = append(changes, change)
+	})
+	e := &Entry{Spider: Get(ts.URL, func(ctx *Context) error {
+		_, err := ctx.DoRequest()
+		return err
+	})}
+
+	sched.runEntry(e, nil)
+	sched.runEntry(e, nil)
+	body = "b"
+	sched.runEntry(e, nil)
+	if len(changes) != 1 {
+		t.Fatalf("Expected a single change but got %d", len(changes))
+	}
+	if changes[0].Previous.Content != "a" || changes[0].Current.Content != "b" {
+		t.Errorf("Unexpected change: %s", changes[0].Summary())
+	}
+}
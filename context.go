@@ -7,7 +7,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
-	"sync"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -30,7 +31,26 @@ type Context struct {
 	request  *http.Request
 	Parent   *Context
 	Children []*Context
-	store    *store
+	store    Store
+	options  []Option
+	session  *Session
+	// loggingIn is set on the context of a login spider run by a Session.
+	loggingIn bool
+	// stream is set on the context of a StreamSpider, whose response never ends.
+	stream         bool
+	changeDetector *changeDetector
+	body           []byte
+	change         *Change
+	retryPolicy    *RetryPolicy
+	state          *State
+	renderer       Renderer
+	renderWait     Wait
+	done           <-chan struct{}
+	downloader     *Downloader
+	redirects      []Redirect
+	// base caches the BaseURL of the response baseOf.
+	base   *url.URL
+	baseOf *http.Response
 }
 
 // NewContext returns a new Context.
@@ -44,20 +64,47 @@ func NewContext() *Context {
 // NewHTTPContext returns a new Context.
 //
 // It creates a new http.Client and a new http.Request with the provided arguments.
-func NewHTTPContext(method, url string, body io.Reader) (*Context, error) {
+// The options are kept in the Context and used by NewClient.
+// A Body set with WithBody replaces the body argument and allows the request to be sent again.
+func NewHTTPContext(method, url string, body io.Reader, opts ...Option) (*Context, error) {
+	cfg := newConfig(opts)
 	ctx := NewContext()
+	ctx.options = opts
+	ctx.session = cfg.session
+	ctx.changeDetector = cfg.changeDetector
+	ctx.retryPolicy = cfg.retryPolicy
+	ctx.renderer = cfg.renderer
+	ctx.renderWait = cfg.renderWait
+	ctx.downloader = cfg.downloader
+	if cfg.store != nil {
+		ctx.store = cfg.store
+	}
 	// Setup client
 	if _, err := ctx.NewClient(); err != nil {
 		return ctx, err
 	}
 	// Request
+	if cfg.body != nil {
+		r, err := cfg.body.Reader()
+		if err != nil {
+			return ctx, err
+		}
+		body = r
+	}
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return ctx, err
 	}
-	req.Close = true
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 Safari/537.36")
+	if cfg.body != nil && req.GetBody == nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			r, err := cfg.body.Reader()
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(r), nil
+		}
+	}
+	cfg.prepareRequest(req)
 	ctx.SetRequest(req)
 	return ctx, nil
 }
@@ -66,10 +113,17 @@ func NewHTTPContext(method, url string, body io.Reader) (*Context, error) {
 //
 // It uses PuerkitoBio's awesome goquery package.
 // It can be found an this url: https://github.com/PuerkitoBio/goquery.
+//
+// The Url of the document is the BaseURL of the context.
 func (c *Context) HTMLParser() (*goquery.Document, error) {
 	res := c.Response()
 	defer res.Body.Close()
-	return goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	doc.Url = c.documentBase(doc)
+	return doc, nil
 }
 
 // JSONParser returns a JSON parser.
@@ -94,7 +148,12 @@ func (c *Context) Response() *http.Response {
 }
 
 // SetResponse set an http.Response
+// The body buffered for the previous response, if any, is dropped.
 func (c *Context) SetResponse(res *http.Response) {
+	if res != c.response {
+		c.body = nil
+		c.change = nil
+	}
 	c.response = res
 }
 
@@ -117,9 +176,7 @@ func (c *Context) Cookies() []*http.Cookie {
 //
 // Note: All the cookies previously will be deleted.
 func (c *Context) ResetCookies() error {
-	jar, err := cookiejar.New(&cookiejar.Options{
-		PublicSuffixList: publicsuffix.List,
-	})
+	jar, err := NewCookieJarFrom(nil)
 	if err != nil {
 		return err
 	}
@@ -134,15 +191,27 @@ func (c *Context) ResetClient() (*http.Client, error) {
 	return client, err
 }
 
-// NewClient create a new http.Client
+// NewClient create a new http.Client configured with the options of this context.
 func (c *Context) NewClient() (*http.Client, error) {
-	jar, err := c.NewCookieJar()
-	if err != nil {
-		return nil, err
+	cfg := newConfig(c.options)
+	jar := cfg.jar
+	if jar == nil && cfg.session != nil {
+		jar = cfg.session.Jar
+	}
+	if jar == nil {
+		j, err := c.NewCookieJar()
+		if err != nil {
+			return nil, err
+		}
+		if jar, err = NewCookieJarFrom(j); err != nil {
+			return nil, err
+		}
 	}
 	client := &http.Client{
-		Jar:       jar,
-		Transport: http.DefaultTransport,
+		Jar:           jar,
+		Transport:     cfg.roundTripper(),
+		Timeout:       cfg.timeout,
+		CheckRedirect: cfg.redirectPolicy,
 	}
 	c.Client = client
 	return client, nil
@@ -159,17 +228,55 @@ func (c *Context) NewCookieJar() (*cookiejar.Jar, error) {
 //
 // This will store the response in this context. To access the response you should do:
 // 		ctx.Response() // to get the http.Response
+//
+// If a Session is attached to this context, its headers are added to the request and
+// the request is replayed after logging in again when the response is detected as logged out.
+// If a RetryPolicy is attached to this context, the request is retried according to it.
 func (c *Context) DoRequest() (*http.Response, error) {
+	if c.Request() == nil {
+		return nil, ErrNoRequest
+	}
+	if c.retryPolicy != nil {
+		return c.retryPolicy.do(c.Request(), c.send)
+	}
+	return c.send()
+}
+
+func (c *Context) send() (*http.Response, error) {
+	if c.session != nil {
+		return c.session.do(c)
+	}
+	return c.do()
+}
+
+func (c *Context) do() (*http.Response, error) {
+	if c.renderer != nil {
+		return c.render()
+	}
 	client := c.Client
 	if client == nil {
 		client = http.DefaultClient
 	}
-	if c.Request() == nil {
-		return nil, ErrNoRequest
+	req := c.Request()
+	// The client adds the cookies of its jar to the request itself,
+	// restore the header so that the request can be sent again.
+	cookie, hasCookie := req.Header["Cookie"]
+	res, err := client.Do(req)
+	if hasCookie {
+		req.Header["Cookie"] = cookie
+	} else {
+		delete(req.Header, "Cookie")
+	}
+	c.redirects = nil
+	if res != nil {
+		c.redirects = redirectChain(res)
+	}
+	if err != nil {
+		return res, err
 	}
-	res, err := client.Do(c.Request())
-	if err == nil {
-		c.SetResponse(res)
+	c.SetResponse(res)
+	if c.changeDetector != nil {
+		err = c.bufferBody(res)
 	}
 	return res, err
 }
@@ -200,53 +307,125 @@ func (c *Context) DoRequestWithExponentialBackOff(condition BackoffCondition, b
 	return c.Response(), err
 }
 
-// Set a value to this context
-func (c *Context) Set(key string, value interface{}) {
-	c.store.set(key, value)
+// Done returns a channel closed when the scheduler running this context is stopped.
+// Long-running spiders should return from Spin when it is closed.
+// It returns nil, a channel never closed, outside of a scheduler.
+func (c *Context) Done() <-chan struct{} {
+	return c.done
+}
+
+// Options returns the options this context has been created with.
+func (c *Context) Options() []Option {
+	return append([]Option(nil), c.options...)
+}
+
+// SetOptions replaces the options of this context.
+// They are used the next time NewClient or ResetClient is called.
+func (c *Context) SetOptions(opts ...Option) {
+	c.options = opts
 }
 
-// Get a value from this context
-func (c *Context) Get(key string) interface{} {
-	return c.store.get(key)
+// withDefaults returns a shallow copy of c whose options are preceded by defaults.
+// It returns c untouched if there are no defaults.
+func (c *Context) withDefaults(defaults []Option) *Context {
+	if len(defaults) == 0 {
+		return c
+	}
+	if c == nil {
+		c = NewContext()
+	}
+	cp := *c
+	cp.options = append(append([]Option(nil), defaults...), c.options...)
+	return &cp
 }
 
 // ExtendWithRequest return a new Context child to the provided context associated with the provided http.Request.
+//
+// Deprecated: ctx is ignored, use Extend.
 func (c *Context) ExtendWithRequest(ctx Context, r *http.Request) *Context {
-	newCtx := NewContext()
-	newCtx.SetRequest(r)
-	newCtx.Parent = c
-	return newCtx
+	return c.Extend(r)
 }
 
-// Set a parent context to the current context.
-// It will also add the current context to the list of children of the parent context.
-func (c *Context) SetParent(parent *Context) {
-	c.Parent = parent
-	parent.Children = append(parent.Children, c)
+// Extend returns a new Context child to this context associated with the provided http.Request.
+//
+// The child shares the client, and thus the cookies, the options, the session and the retry policy of this context.
+// If r is for the same host, the headers of the request of this context are added to r unless r already sets them.
+// Otherwise r only gets the headers set by the options, without Authorization, Cookie and Proxy-Authorization,
+// like net/http does when following a redirect to another host.
+// Values set in the child are only visible in the child and its own children,
+// values of this context are visible through Get.
+func (c *Context) Extend(r *http.Request) *Context {
+	c.inheritHeader(r)
+	return c.newChild(r)
 }
 
-// NewKVStore returns a new store.
-func NewKVStore() *store {
-	return &store{
-		kv: make(map[string]interface{}),
-	}
+// newChild returns a child of c for req sharing its client and options.
+func (c *Context) newChild(req *http.Request) *Context {
+	treeMu.RLock()
+	child := *c
+	treeMu.RUnlock()
+	child.request = req
+	child.response = nil
+	child.body = nil
+	child.change = nil
+	child.store = NewKVStore()
+	child.Parent = nil
+	child.Children = make([]*Context, 0)
+	child.SetParent(c)
+	return &child
 }
 
-type store struct {
-	sync.RWMutex
-	kv map[string]interface{}
+// credentialHeaders are not inherited by a request to another host.
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+func (c *Context) inheritHeader(r *http.Request) {
+	parent := c.Request()
+	if parent == nil || !sameHost(parent.URL, r.URL) {
+		defaults := &http.Request{Header: make(http.Header)}
+		newConfig(c.options).prepareRequest(defaults)
+		if parent != nil {
+			for _, k := range credentialHeaders {
+				defaults.Header.Del(k)
+			}
+		}
+		parent = defaults
+	}
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Close = parent.Close
+	for k, v := range parent.Header {
+		if k == "Cookie" || k == "Content-Length" {
+			continue
+		}
+		if _, ok := r.Header[k]; !ok {
+			r.Header[k] = append([]string(nil), v...)
+		}
+	}
 }
 
-func (s *store) set(key string, value interface{}) {
-	s.Lock()
-	defer s.Unlock()
-	s.kv[key] = value
+func sameHost(a, b *url.URL) bool {
+	return a != nil && b != nil && strings.EqualFold(a.Host, b.Host)
 }
 
-func (s *store) get(key string) interface{} {
-	s.RLock()
-	defer s.RUnlock()
-	return s.kv[key]
+// Set a parent context to the current context.
+// It will also add the current context to the list of children of the parent context
+// and remove it from the children of its previous parent.
+func (c *Context) SetParent(parent *Context) {
+	treeMu.Lock()
+	defer treeMu.Unlock()
+	if old := c.Parent; old != nil {
+		for i, child := range old.Children {
+			if child == c {
+				old.Children = append(old.Children[:i:i], old.Children[i+1:]...)
+				break
+			}
+		}
+	}
+	c.Parent = parent
+	if parent != nil {
+		parent.Children = append(parent.Children, c)
+	}
 }
 
 type BackoffCondition func(*http.Response) error
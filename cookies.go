@@ -0,0 +1,457 @@
+package spider
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	ErrUnknownCookieFormat = errors.New("Unknown cookie format")
+)
+
+// CookieFormat is a serialization format for cookies.
+type CookieFormat int
+
+const (
+	// NetscapeFormat is the cookies.txt format used by curl, wget and browser extensions.
+	NetscapeFormat CookieFormat = iota
+	// JSONFormat is a JSON array of cookies.
+	JSONFormat
+)
+
+// StoredCookie is a cookie as saved by a CookieJar.
+// An Expires equal to zero means that it is a session cookie.
+type StoredCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain"`
+	Path     string `json:"path"`
+	Expires  int64  `json:"expires"`
+	Secure   bool   `json:"secure"`
+	HTTPOnly bool   `json:"httpOnly"`
+	HostOnly bool   `json:"hostOnly"`
+}
+
+func (s StoredCookie) key() string {
+	return s.Domain + ";" + s.Path + ";" + s.Name
+}
+
+func (s StoredCookie) expired(now time.Time) bool {
+	return s.Expires != 0 && s.Expires <= now.Unix()
+}
+
+// url returns an url the cookie can be set from.
+func (s StoredCookie) url() *url.URL {
+	scheme := "http"
+	if s.Secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: s.Domain, Path: s.Path}
+}
+
+func (s StoredCookie) cookie() *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.Name,
+		Value:    s.Value,
+		Path:     s.Path,
+		Secure:   s.Secure,
+		HttpOnly: s.HTTPOnly,
+	}
+	if !s.HostOnly {
+		c.Domain = s.Domain
+	}
+	if s.Expires != 0 {
+		c.Expires = time.Unix(s.Expires, 0)
+	}
+	return c
+}
+
+// CookieJar is an http.CookieJar that keeps track of its cookies so they can be exported and saved.
+//
+// A CookieJar opened with OpenCookieJar is backed by a file: it is saved CookieSaveDelay after a change,
+// so a burst of responses setting cookies is written once.
+// Errors while saving automatically are ignored, call Save to check them.
+// It is safe for concurrent use and can be shared between contexts.
+type CookieJar struct {
+	mu        sync.Mutex
+	jar       *cookiejar.Jar
+	cookies   map[string]StoredCookie
+	path      string
+	saveMu    sync.Mutex
+	saveTimer *time.Timer
+}
+
+// CookieSaveDelay is the delay after which a file backed CookieJar saves its changes.
+var CookieSaveDelay = time.Second
+
+// NewCookieJarFrom returns a CookieJar storing its cookies in the provided jar.
+// A new jar is created if it is nil.
+func NewCookieJarFrom(jar *cookiejar.Jar) (*CookieJar, error) {
+	if jar == nil {
+		var err error
+		jar, err = cookiejar.New(&cookiejar.Options{
+			PublicSuffixList: publicsuffix.List,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &CookieJar{
+		jar:     jar,
+		cookies: make(map[string]StoredCookie),
+	}, nil
+}
+
+// OpenCookieJar returns a CookieJar backed by the file at the provided path.
+// The cookies of the file are loaded if it exists. The file uses JSONFormat.
+func OpenCookieJar(filename string) (*CookieJar, error) {
+	j, err := NewCookieJarFrom(nil)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filename)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+		if err := j.Import(f, JSONFormat); err != nil {
+			return nil, err
+		}
+	}
+	j.path = filename
+	return j, nil
+}
+
+// SetCookies implements http.CookieJar.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.setCookies(u, cookies)
+	if j.path != "" {
+		j.scheduleSave()
+	}
+}
+
+// setCookies sets the cookies in the inner jar and keeps track of the ones it accepted.
+func (j *CookieJar) setCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	now := time.Now()
+	for _, c := range cookies {
+		s := storedCookieFrom(u, c, now)
+		if c.MaxAge < 0 || s.expired(now) {
+			delete(j.cookies, s.key())
+			continue
+		}
+		if j.accepted(s) {
+			j.cookies[s.key()] = s
+		}
+	}
+	j.mu.Unlock()
+}
+
+// accepted reports whether the inner jar kept the cookie.
+func (j *CookieJar) accepted(s StoredCookie) bool {
+	for _, c := range j.jar.Cookies(s.url()) {
+		if c.Name == s.Name && c.Value == s.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *CookieJar) scheduleSave() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.saveTimer == nil {
+		j.saveTimer = time.AfterFunc(CookieSaveDelay, func() {
+			j.Save()
+		})
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// All returns all the cookies of the jar that have not expired.
+func (j *CookieJar) All() []StoredCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	cookies := make([]StoredCookie, 0, len(j.cookies))
+	for k, s := range j.cookies {
+		if s.expired(now) {
+			delete(j.cookies, k)
+			continue
+		}
+		cookies = append(cookies, s)
+	}
+	return cookies
+}
+
+// Save writes the cookies to the file of the jar.
+// It does nothing if the jar is not backed by a file.
+func (j *CookieJar) Save() error {
+	if j.path == "" {
+		return nil
+	}
+	j.mu.Lock()
+	if j.saveTimer != nil {
+		j.saveTimer.Stop()
+		j.saveTimer = nil
+	}
+	j.mu.Unlock()
+	j.saveMu.Lock()
+	defer j.saveMu.Unlock()
+	// The file holds session cookies: it is only readable by its owner.
+	tmp := j.path + ".tmp"
+	os.Remove(tmp)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = j.Export(f, JSONFormat)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Export writes the cookies of the jar using the provided format.
+func (j *CookieJar) Export(w io.Writer, format CookieFormat) error {
+	return writeCookies(w, j.All(), format)
+}
+
+// Import reads cookies using the provided format and adds them to the jar.
+// Expired cookies are ignored.
+func (j *CookieJar) Import(r io.Reader, format CookieFormat) error {
+	cookies, err := readCookies(r, format)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, s := range cookies {
+		if !s.expired(now) {
+			j.setCookies(s.url(), []*http.Cookie{s.cookie()})
+		}
+	}
+	return j.Save()
+}
+
+func storedCookieFrom(u *url.URL, c *http.Cookie, now time.Time) StoredCookie {
+	s := StoredCookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Domain:   strings.TrimPrefix(strings.ToLower(c.Domain), "."),
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HTTPOnly: c.HttpOnly,
+	}
+	if s.Domain == "" {
+		s.Domain = strings.ToLower(u.Hostname())
+		s.HostOnly = true
+	}
+	if s.Path == "" || s.Path[0] != '/' {
+		s.Path = defaultCookiePath(u.Path)
+	}
+	switch {
+	case c.MaxAge > 0:
+		s.Expires = now.Add(time.Duration(c.MaxAge) * time.Second).Unix()
+	case !c.Expires.IsZero():
+		s.Expires = c.Expires.Unix()
+	}
+	return s
+}
+
+// defaultCookiePath implements the default-path algorithm of RFC 6265 section 5.1.4.
+func defaultCookiePath(p string) string {
+	if p == "" || p[0] != '/' || strings.Count(p, "/") == 1 {
+		return "/"
+	}
+	return path.Dir(p)
+}
+
+func writeCookies(w io.Writer, cookies []StoredCookie, format CookieFormat) error {
+	switch format {
+	case JSONFormat:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cookies)
+	case NetscapeFormat:
+		bw := bufio.NewWriter(w)
+		fmt.Fprintln(bw, "# Netscape HTTP Cookie File")
+		for _, s := range cookies {
+			domain := s.Domain
+			if !s.HostOnly {
+				domain = "." + domain
+			}
+			if s.HTTPOnly {
+				domain = "#HttpOnly_" + domain
+			}
+			fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+				domain, netscapeBool(!s.HostOnly), s.Path, netscapeBool(s.Secure), s.Expires, s.Name, s.Value)
+		}
+		return bw.Flush()
+	}
+	return ErrUnknownCookieFormat
+}
+
+func readCookies(r io.Reader, format CookieFormat) ([]StoredCookie, error) {
+	switch format {
+	case JSONFormat:
+		var cookies []StoredCookie
+		err := json.NewDecoder(r).Decode(&cookies)
+		if err == io.EOF {
+			err = nil
+		}
+		return cookies, err
+	case NetscapeFormat:
+		var cookies []StoredCookie
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+			if httpOnly {
+				line = strings.TrimPrefix(line, "#HttpOnly_")
+			}
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			fields := strings.Split(line, "\t")
+			if len(fields) != 7 {
+				return nil, fmt.Errorf("Malformed cookie line: %q", line)
+			}
+			expires, err := strconv.ParseInt(fields[4], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			cookies = append(cookies, StoredCookie{
+				Domain:   strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+				HostOnly: strings.ToUpper(fields[1]) != "TRUE",
+				Path:     fields[2],
+				Secure:   strings.ToUpper(fields[3]) == "TRUE",
+				Expires:  expires,
+				Name:     fields[5],
+				Value:    fields[6],
+				HTTPOnly: httpOnly,
+			})
+		}
+		return cookies, scanner.Err()
+	}
+	return nil, ErrUnknownCookieFormat
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+var sharedJars = struct {
+	sync.Mutex
+	jars map[string]*CookieJar
+}{jars: make(map[string]*CookieJar)}
+
+// SharedCookieJar returns the CookieJar registered under the provided name.
+// A new in-memory jar is registered if there is none.
+func SharedCookieJar(name string) *CookieJar {
+	sharedJars.Lock()
+	defer sharedJars.Unlock()
+	if jar, ok := sharedJars.jars[name]; ok {
+		return jar
+	}
+	jar, _ := NewCookieJarFrom(nil)
+	sharedJars.jars[name] = jar
+	return jar
+}
+
+// RegisterCookieJar registers a CookieJar under the provided name, for example one returned by OpenCookieJar.
+// It replaces any jar previously registered with this name.
+func RegisterCookieJar(name string, jar *CookieJar) {
+	sharedJars.Lock()
+	defer sharedJars.Unlock()
+	sharedJars.jars[name] = jar
+}
+
+// WithCookieJar sets the cookie jar of the http.Client.
+func WithCookieJar(jar http.CookieJar) Option {
+	return func(c *config) {
+		c.jar = jar
+	}
+}
+
+// WithSharedCookieJar sets the cookie jar of the http.Client to the one registered under the provided name.
+// Contexts using the same name share their cookies, even across scheduler entries.
+func WithSharedCookieJar(name string) Option {
+	return func(c *config) {
+		c.jar = SharedCookieJar(name)
+	}
+}
+
+// ExportCookies writes the cookies of this context using the provided format.
+//
+// All the cookies are exported if the jar of the client is a *CookieJar.
+// Otherwise only the cookies for the request URL are exported.
+func (c *Context) ExportCookies(w io.Writer, format CookieFormat) error {
+	if c.Client == nil || c.Client.Jar == nil {
+		return ErrNoClient
+	}
+	if jar, ok := c.Client.Jar.(*CookieJar); ok {
+		return jar.Export(w, format)
+	}
+	if c.Request() == nil {
+		return ErrNoRequest
+	}
+	u := c.Request().URL
+	var cookies []StoredCookie
+	for _, cookie := range c.Client.Jar.Cookies(u) {
+		cookie.Path = "/"
+		cookies = append(cookies, storedCookieFrom(u, cookie, time.Now()))
+	}
+	return writeCookies(w, cookies, format)
+}
+
+// ImportCookies reads cookies using the provided format and adds them to the jar of the client.
+func (c *Context) ImportCookies(r io.Reader, format CookieFormat) error {
+	if c.Client == nil || c.Client.Jar == nil {
+		return ErrNoClient
+	}
+	if jar, ok := c.Client.Jar.(*CookieJar); ok {
+		return jar.Import(r, format)
+	}
+	cookies, err := readCookies(r, format)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, s := range cookies {
+		if !s.expired(now) {
+			c.Client.Jar.SetCookies(s.url(), []*http.Cookie{s.cookie()})
+		}
+	}
+	return nil
+}
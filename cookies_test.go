@@ -0,0 +1,157 @@
+package spider
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieJarExportImport(t *testing.T) {
+	u, _ := url.Parse("http://www.example.com/account/login")
+	jar, _ := NewCookieJarFrom(nil)
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "abc", HttpOnly: true},
+		{Name: "lang", Value: "en", Domain: ".example.com", Path: "/", MaxAge: 3600},
+		{Name: "old", Value: "x", Expires: time.Now().Add(-time.Hour)},
+	})
+
+	for _, format := range []CookieFormat{NetscapeFormat, JSONFormat} {
+		var buf bytes.Buffer
+		if err := jar.Export(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+		other, _ := NewCookieJarFrom(nil)
+		if err := other.Import(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(other.All()); n != 2 {
+			t.Errorf("Format %d: expected 2 cookies but got %d", format, n)
+		}
+		cookies := other.Cookies(u)
+		if len(cookies) != 2 {
+			t.Errorf("Format %d: expected 2 cookies for %s but got %v", format, u, cookies)
+		}
+		sub, _ := url.Parse("http://static.example.com/")
+		if cookies := other.Cookies(sub); len(cookies) != 1 || cookies[0].Name != "lang" {
+			t.Errorf("Format %d: expected only the domain cookie for %s but got %v", format, sub, cookies)
+		}
+	}
+}
+
+func TestCookieJarDeletion(t *testing.T) {
+	u, _ := url.Parse("http://example.com/")
+	jar, _ := NewCookieJarFrom(nil)
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "abc"}})
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", MaxAge: -1}})
+	if n := len(jar.All()); n != 0 {
+		t.Errorf("Expected the cookie to be deleted, got %d cookies", n)
+	}
+}
+
+func TestCookieJarRejectedCookies(t *testing.T) {
+	u, _ := url.Parse("http://www.example.com/")
+	jar, _ := NewCookieJarFrom(nil)
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "abc"},
+		{Name: "evil", Value: "x", Domain: "other.com"},
+		{Name: "tld", Value: "x", Domain: "com"},
+	})
+	all := jar.All()
+	if len(all) != 1 || all[0].Name != "session" {
+		t.Errorf("Expected only the cookie accepted by the jar, got %+v", all)
+	}
+}
+
+func TestNetscapeFormat(t *testing.T) {
+	txt := "# Netscape HTTP Cookie File\n" +
+		"#HttpOnly_.example.com\tTRUE\t/\tTRUE\t0\tsid\t42\n" +
+		"example.org\tFALSE\t/\tFALSE\t0\tempty\t\n"
+	cookies, err := readCookies(strings.NewReader(txt), NetscapeFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies but got %d", len(cookies))
+	}
+	expected := StoredCookie{Name: "sid", Value: "42", Domain: "example.com", Path: "/", Secure: true, HTTPOnly: true}
+	if cookies[0] != expected {
+		t.Errorf("Expected %+v but got %+v", expected, cookies[0])
+	}
+	if !cookies[1].HostOnly || cookies[1].Value != "" {
+		t.Errorf("Expected a host only cookie with an empty value, got %+v", cookies[1])
+	}
+}
+
+func TestFileBackedCookieJar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "cookies.json")
+	u, _ := url.Parse("http://example.com/")
+
+	jar, err := OpenCookieJar(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "abc", MaxAge: 3600}})
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Expected the save to be delayed, got %v", err)
+	}
+	if err := jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the cookie file to be private, got %v", info.Mode())
+	}
+
+	reopened, err := OpenCookieJar(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookies := reopened.Cookies(u); len(cookies) != 1 || cookies[0].Value != "abc" {
+		t.Errorf("Expected the session cookie to be loaded, got %v", cookies)
+	}
+}
+
+func TestSharedCookieJar(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			return
+		}
+		if _, err := r.Cookie("session"); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	login, _ := NewHTTPContext("GET", ts.URL+"/login", nil, WithSharedCookieJar("test-shared"))
+	if _, err := login.DoRequest(); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewHTTPContext("GET", ts.URL+"/private", nil, WithSharedCookieJar("test-shared"))
+	res, err := other.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected the session to be shared, got %s", res.Status)
+	}
+
+	var buf bytes.Buffer
+	if err := other.ExportCookies(&buf, NetscapeFormat); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\tsession\tabc") {
+		t.Errorf("Expected the session cookie to be exported, got %q", buf.String())
+	}
+}
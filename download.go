@@ -0,0 +1,435 @@
+package spider
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoDownloader        = errors.New("No downloader has been set")
+	ErrSizeMismatch        = errors.New("Downloaded size does not match the expected size")
+	ErrChecksumMismatch    = errors.New("Downloaded content does not match the expected checksum")
+	ErrUnknownChecksum     = errors.New("Unknown checksum algorithm")
+	ErrInvalidContentRange = errors.New("Invalid Content-Range in response")
+)
+
+// DefaultMaxDownloads is the number of concurrent downloads of a Downloader when MaxConcurrent is zero.
+const DefaultMaxDownloads = 4
+
+// Downloader streams files to a directory.
+//
+// Interrupted downloads are kept as .part files named after their URL and resumed with Range requests.
+// Files are deduplicated by their SHA-256: downloading a content already downloaded
+// returns the existing file instead of keeping a copy. A file never replaces another one:
+// if the name is taken, a numeric suffix is added, report-1.pdf for instance.
+// Downloads bypass the Cache set with WithCache.
+type Downloader struct {
+	Dir string
+	// MaxConcurrent limits the number of downloads at the same time.
+	MaxConcurrent int
+	// Index maps the SHA-256 of the downloaded files to their path.
+	// Use a FileStore to deduplicate across restarts.
+	Index Store
+
+	once  sync.Once
+	sem   chan struct{}
+	parts sync.Map
+	// indexMu makes the lookup of a content in the Index and its registration atomic.
+	indexMu sync.Mutex
+}
+
+// NewDownloader returns a new Downloader storing its files in dir.
+// The directory is created if it does not exist.
+func NewDownloader(dir string, maxConcurrent int) (*Downloader, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxDownloads
+	}
+	return &Downloader{
+		Dir:           dir,
+		MaxConcurrent: maxConcurrent,
+		Index:         NewKVStore(),
+	}, nil
+}
+
+// Download describes a file to download.
+type Download struct {
+	URL string
+	// Filename is the path of the file relative to the directory of the Downloader.
+	// It defaults to the filename of the Content-Disposition of the response,
+	// or to the last element of the path of the URL.
+	Filename string
+	// Size is the expected size of the file, checked if positive.
+	Size int64
+	// Checksum is the expected checksum of the file as "algorithm:hex", algorithm being sha256, sha1 or md5.
+	Checksum string
+	// Progress is called after each chunk written to disk.
+	Progress func(Progress)
+}
+
+// Progress reports the progress of a download.
+type Progress struct {
+	URL     string
+	Written int64
+	// Total is the size of the file, or -1 if the server did not tell it.
+	Total int64
+}
+
+// DownloadResult describes a completed download.
+type DownloadResult struct {
+	// Path is the path of the file on disk, the one of an identical file downloaded before if Duplicate is set.
+	Path      string
+	Size      int64
+	SHA256    string
+	Resumed   bool
+	Duplicate bool
+}
+
+// WithDownloader sets the Downloader used by Context.Download.
+// Passed to NewScheduler, it limits the concurrent downloads of every spider.
+func WithDownloader(d *Downloader) Option {
+	return func(c *config) {
+		c.downloader = d
+	}
+}
+
+// Download streams a file to the directory of the Downloader set with WithDownloader.
+//
+// The request is made by a child Context created with Extend, so it shares the client,
+// the cookies and the headers of this context. The Timeout of the client does not apply:
+// a download is only stopped by an error or when the scheduler is stopped. A partial file left by a previous attempt is resumed
+// with a Range request. The size and the checksum are checked if they are set in d.
+func (c *Context) Download(d Download) (*DownloadResult, error) {
+	if c.downloader == nil {
+		return nil, ErrNoDownloader
+	}
+	return c.downloader.download(c, d)
+}
+
+func (dl *Downloader) download(parent *Context, d Download) (*DownloadResult, error) {
+	u, err := url.Parse(d.URL)
+	if parent.Request() != nil {
+		u, err = parent.AbsURL(d.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var check hash.Hash
+	var expected string
+	if d.Checksum != "" {
+		if check, expected, err = parseChecksum(d.Checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	dl.acquire()
+	defer dl.release()
+
+	part := dl.partPath(u)
+	unlock := dl.lockPart(part)
+	defer unlock()
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	hashes := io.MultiWriter(sum)
+	if check != nil {
+		hashes = io.MultiWriter(sum, check)
+	}
+	// Hash the partial content before resuming.
+	offset, err := io.Copy(hashes, f)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	cctx, cancel := context.WithCancel(withoutCache(req.Context()))
+	defer cancel()
+	done := parent.Done()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-cctx.Done():
+		}
+	}()
+	req = req.WithContext(cctx)
+	ctx := parent.Extend(req)
+	// The body is streamed to disk, never buffered or rendered, for as long as it takes.
+	ctx.changeDetector = nil
+	ctx.renderer = nil
+	if ctx.Client != nil && ctx.Client.Timeout != 0 {
+		client := *ctx.Client
+		client.Timeout = 0
+		ctx.Client = &client
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := ctx.DoRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	total := int64(-1)
+	resumed := false
+	switch {
+	case offset > 0 && res.StatusCode == http.StatusPartialContent:
+		start, size, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		if start != offset {
+			return nil, ErrInvalidContentRange
+		}
+		total, resumed = size, true
+	case offset > 0 && res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is complete only if the server tells that it has its size.
+		if size, err := parseUnsatisfiedRange(res.Header.Get("Content-Range")); err != nil || size != offset {
+			f.Close()
+			os.Remove(part)
+			return nil, ErrInvalidContentRange
+		}
+		total, resumed = offset, true
+		res.Body = http.NoBody
+	case res.StatusCode == http.StatusOK:
+		// The server does not support ranges or the file is new: start over.
+		if offset > 0 {
+			sum.Reset()
+			if check != nil {
+				check.Reset()
+			}
+			offset = 0
+		}
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+		total = res.ContentLength
+	default:
+		return nil, fmt.Errorf("Download %s: %s", u, res.Status)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	w := &progressWriter{w: io.MultiWriter(f, hashes), fn: d.Progress, progress: Progress{URL: u.String(), Written: offset, Total: total}}
+	written, err := io.Copy(w, res.Body)
+	if err != nil {
+		return nil, err
+	}
+	size := offset + written
+
+	if (d.Size > 0 && size != d.Size) || (total >= 0 && size != total) {
+		f.Close()
+		os.Remove(part)
+		return nil, ErrSizeMismatch
+	}
+	if check != nil && hex.EncodeToString(check.Sum(nil)) != expected {
+		f.Close()
+		os.Remove(part)
+		return nil, ErrChecksumMismatch
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	result := &DownloadResult{Size: size, SHA256: hex.EncodeToString(sum.Sum(nil)), Resumed: resumed}
+	dl.indexMu.Lock()
+	defer dl.indexMu.Unlock()
+	if existing, ok := dl.Index.Get(result.SHA256); ok {
+		if p, _ := existing.(string); p != "" {
+			if _, err := os.Stat(p); err == nil {
+				os.Remove(part)
+				result.Path, result.Duplicate = p, true
+				return result, nil
+			}
+		}
+	}
+	name := d.Filename
+	if name == "" {
+		name = dispositionFilename(res.Header)
+	}
+	if name == "" {
+		name = downloadFilename(u)
+	}
+	dest, err := dl.reserve(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(part, dest); err != nil {
+		os.Remove(dest)
+		return nil, err
+	}
+	dl.Index.Set(result.SHA256, dest)
+	result.Path = dest
+	return result, nil
+}
+
+// partPath returns the path of the partial file of the download of u.
+func (dl *Downloader) partPath(u *url.URL) string {
+	sum := sha256.Sum256([]byte(u.String()))
+	return filepath.Join(dl.Dir, hex.EncodeToString(sum[:16])+".part")
+}
+
+// lockPart prevents concurrent downloads of the same URL from writing the same partial file.
+func (dl *Downloader) lockPart(part string) func() {
+	mu, _ := dl.parts.LoadOrStore(part, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// reserve creates an empty file for name in the directory of the Downloader and returns its path.
+// A numeric suffix is added to the name if a file already has it.
+func (dl *Downloader) reserve(name string) (string, error) {
+	dest := filepath.Join(dl.Dir, filepath.Clean("/"+name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	for i := 0; ; i++ {
+		p := dest
+		if i > 0 {
+			p = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return p, f.Close()
+	}
+}
+
+func (dl *Downloader) acquire() {
+	dl.once.Do(func() {
+		max := dl.MaxConcurrent
+		if max <= 0 {
+			max = DefaultMaxDownloads
+		}
+		dl.sem = make(chan struct{}, max)
+		if dl.Index == nil {
+			dl.Index = NewKVStore()
+		}
+	})
+	dl.sem <- struct{}{}
+}
+
+func (dl *Downloader) release() {
+	<-dl.sem
+}
+
+type progressWriter struct {
+	w        io.Writer
+	fn       func(Progress)
+	progress Progress
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.progress.Written += int64(n)
+	if p.fn != nil && n > 0 {
+		p.fn(p.progress)
+	}
+	return n, err
+}
+
+// downloadFilename returns the last element of the path of u, or "download" if it has none.
+func downloadFilename(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || name == "" {
+		return "download"
+	}
+	return name
+}
+
+// dispositionFilename returns the file name of the Content-Disposition header, without its directories.
+func dispositionFilename(h http.Header) string {
+	_, params, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	name := filepath.Base(filepath.Clean("/" + filepath.FromSlash(params["filename"])))
+	if name == "." || name == string(filepath.Separator) {
+		return ""
+	}
+	return name
+}
+
+func parseChecksum(s string) (hash.Hash, string, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return nil, "", ErrUnknownChecksum
+	}
+	expected := strings.ToLower(s[i+1:])
+	switch strings.ToLower(s[:i]) {
+	case "sha256":
+		return sha256.New(), expected, nil
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "md5":
+		return md5.New(), expected, nil
+	}
+	return nil, "", ErrUnknownChecksum
+}
+
+// parseContentRange parses a "bytes start-end/size" Content-Range header. size is -1 if unknown.
+func parseContentRange(s string) (start, size int64, err error) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, ErrInvalidContentRange
+	}
+	parts := strings.SplitN(strings.TrimPrefix(s, "bytes "), "/", 2)
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(parts) != 2 || len(bounds) != 2 {
+		return 0, 0, ErrInvalidContentRange
+	}
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, ErrInvalidContentRange
+	}
+	size = -1
+	if parts[1] != "*" {
+		if size, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, ErrInvalidContentRange
+		}
+	}
+	return start, size, nil
+}
+
+// parseUnsatisfiedRange parses the "bytes */size" Content-Range header of a 416 response.
+func parseUnsatisfiedRange(s string) (int64, error) {
+	if !strings.HasPrefix(s, "bytes */") {
+		return 0, ErrInvalidContentRange
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(s, "bytes */"), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidContentRange
+	}
+	return size, nil
+}
@@ -0,0 +1,314 @@
+package spider
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var downloadContent = bytes.Repeat([]byte("0123456789"), 10000)
+
+func downloadServer(ranges *[]string) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "file.pdf", time.Time{}, bytes.NewReader(downloadContent))
+	}))
+}
+
+func newTestDownloader(t *testing.T, max int) (*Downloader, func()) {
+	dir, err := ioutil.TempDir("", "downloads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl, err := NewDownloader(dir, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dl, func() { os.RemoveAll(dir) }
+}
+
+func TestDownloadResume(t *testing.T) {
+	var ranges []string
+	ts := downloadServer(&ranges)
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 1)
+	defer cleanup()
+
+	// A previous attempt stopped halfway.
+	u, _ := url.Parse(ts.URL + "/docs/report.pdf")
+	if err := ioutil.WriteFile(dl.partPath(u), downloadContent[:40000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(downloadContent)
+	var last Progress
+	ctx, err := NewHTTPContext("GET", ts.URL+"/docs/", nil, WithDownloader(dl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.Download(Download{
+		URL:      "report.pdf",
+		Size:     int64(len(downloadContent)),
+		Checksum: "sha256:" + hex.EncodeToString(sum[:]),
+		Progress: func(p Progress) { last = p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Resumed || res.Size != int64(len(downloadContent)) || ranges[0] != "bytes=40000-" {
+		t.Errorf("Expected the download to be resumed, got %+v with ranges %v", res, ranges)
+	}
+	if last.Written != res.Size || last.Total != res.Size {
+		t.Errorf("Unexpected last progress %+v", last)
+	}
+	data, err := ioutil.ReadFile(res.Path)
+	if err != nil || !bytes.Equal(data, downloadContent) {
+		t.Error("Expected the downloaded file to match the content")
+	}
+	if _, err := os.Stat(dl.partPath(u)); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be removed")
+	}
+}
+
+func TestDownloadChecksumAndDedup(t *testing.T) {
+	var ranges []string
+	ts := downloadServer(&ranges)
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 2)
+	defer cleanup()
+
+	ctx, err := NewHTTPContext("GET", ts.URL, nil, WithDownloader(dl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.Download(Download{URL: ts.URL + "/a.zip", Checksum: "md5:00"}); err != ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch but got %v", err)
+	}
+	first, err := ctx.Download(Download{URL: ts.URL + "/a.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ctx.Download(Download{URL: ts.URL + "/mirror/b.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Duplicate || second.Path != first.Path {
+		t.Errorf("Expected the identical content to be deduplicated, got %+v", second)
+	}
+	if _, err := os.Stat(filepath.Join(dl.Dir, "b.zip")); !os.IsNotExist(err) {
+		t.Error("Expected no copy of a duplicate file")
+	}
+
+	if _, err := NewContext().Download(Download{URL: ts.URL}); err != ErrNoDownloader {
+		t.Errorf("Expected ErrNoDownloader but got %v", err)
+	}
+}
+
+func TestDownloadConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	var current, max int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+		mu.Lock()
+		current--
+		mu.Unlock()
+	}))
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 2)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, _ := NewHTTPContext("GET", ts.URL, nil, WithDownloader(dl))
+			if _, err := ctx.Download(Download{URL: ts.URL + "/" + strings.Repeat("f", i+1)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if max > 2 {
+		t.Errorf("Expected at most 2 concurrent downloads but got %d", max)
+	}
+}
+
+func TestDownloadNaming(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/export" {
+			w.Header().Set("Content-Disposition", `attachment; filename="../report.csv"`)
+		}
+		w.Write([]byte(r.URL.String()))
+	}))
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 1)
+	defer cleanup()
+	ctx, _ := NewHTTPContext("GET", ts.URL, nil, WithDownloader(dl))
+
+	res, err := ctx.Download(Download{URL: ts.URL + "/export?id=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != filepath.Join(dl.Dir, "report.csv") {
+		t.Errorf("Expected the name of the Content-Disposition, got %s", res.Path)
+	}
+	first, err := ctx.Download(Download{URL: ts.URL + "/a/data.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ctx.Download(Download{URL: ts.URL + "/b/data.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Path != filepath.Join(dl.Dir, "data.json") || second.Path != filepath.Join(dl.Dir, "data-1.json") {
+		t.Errorf("Expected a taken name to get a suffix, got %s and %s", first.Path, second.Path)
+	}
+	if data, _ := ioutil.ReadFile(first.Path); string(data) != "/a/data.json" {
+		t.Errorf("Expected the first file to be kept, got %q", data)
+	}
+	if p, _ := dl.Index.Get(first.SHA256); p != first.Path {
+		t.Errorf("Expected the index to point to the first file, got %v", p)
+	}
+}
+
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	contentRange := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", contentRange)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 1)
+	defer cleanup()
+	ctx, _ := NewHTTPContext("GET", ts.URL, nil, WithDownloader(dl))
+	u, _ := url.Parse(ts.URL + "/file.bin")
+
+	ioutil.WriteFile(dl.partPath(u), downloadContent[:100], 0644)
+	contentRange = "bytes */200"
+	if _, err := ctx.Download(Download{URL: u.String()}); err != ErrInvalidContentRange {
+		t.Errorf("Expected ErrInvalidContentRange for a smaller partial file, got %v", err)
+	}
+	if _, err := os.Stat(dl.partPath(u)); !os.IsNotExist(err) {
+		t.Error("Expected the invalid partial file to be removed")
+	}
+
+	ioutil.WriteFile(dl.partPath(u), downloadContent[:100], 0644)
+	contentRange = "bytes */100"
+	res, err := ctx.Download(Download{URL: u.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Resumed || res.Size != 100 {
+		t.Errorf("Expected the complete partial file to be kept, got %+v", res)
+	}
+}
+
+func TestDownloadBypassesCache(t *testing.T) {
+	var ranges []string
+	ts := downloadServer(&ranges)
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 1)
+	defer cleanup()
+	cache := NewMemoryCache()
+	ctx, _ := NewHTTPContext("GET", ts.URL, nil, WithDownloader(dl), WithCache(cache))
+
+	if _, err := ctx.Download(Download{URL: ts.URL + "/file.pdf"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(cacheKey("GET", ts.URL+"/file.pdf")); ok {
+		t.Error("Expected the download not to be cached")
+	}
+}
+
+func TestDownloadIgnoresClientTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(downloadContent[:10])
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(150 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write(downloadContent[10:])
+	}))
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 1)
+	defer cleanup()
+
+	ctx, err := NewHTTPContext("GET", ts.URL, nil, WithDownloader(dl), WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ctx.Download(Download{URL: ts.URL + "/slow.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != int64(len(downloadContent)) {
+		t.Errorf("Expected the whole file, got %d bytes", result.Size)
+	}
+	if ctx.Client.Timeout != 50*time.Millisecond {
+		t.Error("Expected the client of the context to keep its timeout")
+	}
+
+	done := make(chan struct{})
+	close(done)
+	ctx.done = done
+	if _, err := ctx.Download(Download{URL: ts.URL + "/stopped.bin"}); err == nil {
+		t.Error("Expected a download to stop with the scheduler")
+	}
+}
+
+func TestDownloadConcurrentDuplicates(t *testing.T) {
+	var ranges []string
+	ts := downloadServer(&ranges)
+	defer ts.Close()
+	dl, cleanup := newTestDownloader(t, 4)
+	defer cleanup()
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		duplicates int
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, _ := NewHTTPContext("GET", ts.URL, nil, WithDownloader(dl))
+			result, err := ctx.Download(Download{URL: ts.URL + "/" + strings.Repeat("f", i+1) + ".pdf"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Duplicate {
+				mu.Lock()
+				duplicates++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if duplicates != 3 {
+		t.Errorf("Expected a single copy of the content, got %d duplicates", duplicates)
+	}
+}
@@ -0,0 +1,74 @@
+package spider
+
+import "time"
+
+// EventType is the type of an Event of the scheduler.
+type EventType int
+
+const (
+	// EventRun is sent when a run or a retry of an entry ends.
+	EventRun EventType = iota
+	// EventChange is sent when the monitored content of an entry changed since its previous run.
+	EventChange
+	// EventCircuit is sent when a circuit of a watched CircuitBreaker changes state.
+	EventCircuit
+)
+
+// Event is something that happened in the scheduler.
+// Only the fields related to its Type are set.
+type Event struct {
+	Type  EventType
+	Time  time.Time
+	Entry *Entry
+
+	// Run is set for EventRun.
+	Run Run
+	// Change is set for EventChange.
+	Change *Change
+	// Circuit is the key of the circuit, a host or an entry name, for EventCircuit.
+	Circuit string
+	From    CircuitState
+	To      CircuitState
+}
+
+// OnEvent registers a function called for every Event of the scheduler.
+// It is called from the goroutine of the run that caused the event.
+func (in *InMemory) OnEvent(fn func(Event)) {
+	in.hooksMu.Lock()
+	defer in.hooksMu.Unlock()
+	in.hooks = append(in.hooks, fn)
+}
+
+// WatchCircuitBreaker sends an EventCircuit every time a circuit of cb changes state.
+//
+// The breakers passed to NewScheduler with WithCircuitBreaker and the ones of the entries are watched automatically.
+func (in *InMemory) WatchCircuitBreaker(cb *CircuitBreaker) {
+	in.hooksMu.Lock()
+	if in.watched[cb] {
+		in.hooksMu.Unlock()
+		return
+	}
+	in.watched[cb] = true
+	in.hooksMu.Unlock()
+
+	cb.Notify(func(key string, from, to CircuitState) {
+		in.emit(Event{
+			Type:    EventCircuit,
+			Circuit: key,
+			From:    from,
+			To:      to,
+		})
+	})
+}
+
+func (in *InMemory) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	in.hooksMu.RLock()
+	hooks := in.hooks
+	in.hooksMu.RUnlock()
+	for _, fn := range hooks {
+		fn(ev)
+	}
+}
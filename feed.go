@@ -0,0 +1,448 @@
+package spider
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownFeedFormat = errors.New("Unknown feed format")
+)
+
+// DefaultMaxSeenItems is the number of GUIDs remembered by a FeedSpider when MaxSeen is zero.
+const DefaultMaxSeenItems = 1000
+
+// feedSeenKey is the prefix of the key of the State holding the GUIDs already seen by a FeedSpider.
+const feedSeenKey = "feed.seen"
+
+// Feed is a feed parsed from RSS, Atom or JSON Feed.
+type Feed struct {
+	Title string
+	Link  string
+	Items []FeedItem
+}
+
+// FeedItem is an entry of a feed, normalized across formats.
+type FeedItem struct {
+	// GUID identifies the item. It falls back to the link, or a hash of the title and date, if the feed has none.
+	GUID       string
+	Title      string
+	Link       string
+	Summary    string
+	Content    string
+	Author     string
+	Categories []string
+	Published  time.Time
+	Updated    time.Time
+}
+
+// FeedFunc is called with the Context of the feed request for each new item of a feed.
+type FeedFunc func(ctx *Context, item FeedItem) error
+
+// FeedSpider is an HTTP spider polling a feed and calling its function for the items not seen by previous runs.
+//
+// The GUIDs already seen are kept in the State of the scheduled entry, so they are only
+// remembered once a run succeeds: items of a failed run are delivered again by the next one.
+type FeedSpider struct {
+	URL string
+	// MaxSeen is the number of GUIDs remembered, DefaultMaxSeenItems if zero.
+	// The GUIDs of the items still in the feed are always remembered:
+	// the oldest GUIDs that left the feed are forgotten first.
+	MaxSeen int
+
+	fn   FeedFunc
+	opts []Option
+}
+
+// NewFeedSpider returns a new FeedSpider polling the feed at url.
+func NewFeedSpider(url string, fn FeedFunc, opts ...Option) *FeedSpider {
+	return &FeedSpider{URL: url, fn: fn, opts: opts}
+}
+
+func (s *FeedSpider) Setup(parent *Context) (*Context, error) {
+	var opts []Option
+	if parent != nil {
+		opts = parent.Options()
+	}
+	return NewHTTPContext("GET", s.URL, nil, append(opts, s.opts...)...)
+}
+
+// seenKey returns the key of the State holding the GUIDs seen in the feed of the spider,
+// so that spiders sharing a State but polling other feeds do not skip their items.
+func (s *FeedSpider) seenKey() string {
+	return feedSeenKey + ":" + s.URL
+}
+
+// Spin fetches the feed and calls the function of the spider for each new item, in the order of the feed.
+func (s *FeedSpider) Spin(ctx *Context) error {
+	res, err := ctx.DoRequest()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Feed %s: %s", s.URL, res.Status)
+	}
+	feed, err := ParseFeed(res.Body)
+	if err != nil {
+		return err
+	}
+
+	state := ctx.State()
+	previous := stringSlice(state.Get(s.seenKey()))
+	known := make(map[string]bool, len(previous))
+	for _, guid := range previous {
+		known[guid] = true
+	}
+	inFeed := make(map[string]bool, len(feed.Items))
+	var current []string
+	for _, item := range feed.Items {
+		if inFeed[item.GUID] {
+			continue
+		}
+		inFeed[item.GUID] = true
+		current = append(current, item.GUID)
+		if known[item.GUID] {
+			continue
+		}
+		if err := s.fn(ctx, item); err != nil {
+			return err
+		}
+		known[item.GUID] = true
+	}
+
+	// The GUIDs are kept from the oldest to the newest seen, those of the feed being the newest.
+	var seen []string
+	for _, guid := range previous {
+		if !inFeed[guid] {
+			seen = append(seen, guid)
+		}
+	}
+	max := s.MaxSeen
+	if max <= 0 {
+		max = DefaultMaxSeenItems
+	}
+	if extra := len(seen) + len(current) - max; extra > 0 {
+		if extra > len(seen) {
+			extra = len(seen)
+		}
+		seen = seen[extra:]
+	}
+	state.Set(s.seenKey(), append(seen, current...))
+	return nil
+}
+
+// stringSlice converts the value of a Store to a []string, including the []interface{} decoded by a FileStore.
+func stringSlice(v interface{}, ok bool) []string {
+	switch v := v.(type) {
+	case []string:
+		return append([]string(nil), v...)
+	case []interface{}:
+		s := make([]string, 0, len(v))
+		for _, e := range v {
+			if str, ok := e.(string); ok {
+				s = append(s, str)
+			}
+		}
+		return s
+	}
+	return nil
+}
+
+// ParseFeed parses an RSS 2.0, RSS 1.0, Atom or JSON Feed document.
+func ParseFeed(r io.Reader) (*Feed, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	data := bytes.TrimSpace(buf.Bytes())
+	if bytes.HasPrefix(data, []byte("{")) {
+		feed, err := parseJSONFeed(data)
+		if err != nil {
+			return nil, err
+		}
+		return feed.normalize(), nil
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, ErrUnknownFeedFormat
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var feed *Feed
+		switch start.Name.Local {
+		case "rss", "RDF":
+			var doc rssDocument
+			err = decoder.DecodeElement(&doc, &start)
+			feed = doc.feed()
+		case "feed":
+			var doc atomFeed
+			err = decoder.DecodeElement(&doc, &start)
+			feed = doc.feed()
+		default:
+			return nil, ErrUnknownFeedFormat
+		}
+		if err != nil {
+			return nil, err
+		}
+		return feed.normalize(), nil
+	}
+}
+
+func (feed *Feed) normalize() *Feed {
+	for i := range feed.Items {
+		feed.Items[i].normalize()
+	}
+	return feed
+}
+
+func (item *FeedItem) normalize() {
+	item.GUID = strings.TrimSpace(item.GUID)
+	item.Title = strings.TrimSpace(item.Title)
+	item.Link = strings.TrimSpace(item.Link)
+	if item.GUID == "" {
+		item.GUID = item.Link
+	}
+	if item.GUID == "" {
+		sum := sha1.Sum([]byte(item.Title + "\x00" + item.Published.String()))
+		item.GUID = hex.EncodeToString(sum[:])
+	}
+	if item.Updated.IsZero() {
+		item.Updated = item.Published
+	}
+}
+
+// rssDocument is an RSS 2.0 document or an RSS 1.0 RDF document, whose items are outside of the channel.
+type rssDocument struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Link  string    `xml:"link"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        string   `xml:"guid"`
+	About       string   `xml:"about,attr"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Author      string   `xml:"author"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+func (doc *rssDocument) feed() *Feed {
+	feed := &Feed{
+		Title: strings.TrimSpace(doc.Channel.Title),
+		Link:  strings.TrimSpace(doc.Channel.Link),
+	}
+	for _, it := range append(doc.Channel.Items, doc.Items...) {
+		item := FeedItem{
+			GUID:       firstNonEmpty(it.GUID, it.About),
+			Title:      it.Title,
+			Link:       it.Link,
+			Summary:    strings.TrimSpace(it.Description),
+			Content:    strings.TrimSpace(it.Content),
+			Author:     strings.TrimSpace(firstNonEmpty(it.Author, it.Creator)),
+			Categories: it.Categories,
+			Published:  parseFeedDate(firstNonEmpty(it.PubDate, it.Date)),
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",innerxml"`
+}
+
+// text returns the content of an Atom text construct, unescaped unless it is XHTML.
+func (t atomText) text() string {
+	if t.Type == "xhtml" {
+		return strings.TrimSpace(t.Body)
+	}
+	var s string
+	if err := xml.Unmarshal([]byte("<t>"+t.Body+"</t>"), &s); err != nil {
+		return strings.TrimSpace(t.Body)
+	}
+	return strings.TrimSpace(s)
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+func atomAlternate(links []atomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+type atomFeed struct {
+	Title   atomText    `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   atomText   `xml:"title"`
+	Links   []atomLink `xml:"link"`
+	Summary atomText   `xml:"summary"`
+	Content atomText   `xml:"content"`
+	Authors []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+func (doc *atomFeed) feed() *Feed {
+	feed := &Feed{
+		Title: doc.Title.text(),
+		Link:  atomAlternate(doc.Links),
+	}
+	for _, e := range doc.Entries {
+		item := FeedItem{
+			GUID:      e.ID,
+			Title:     e.Title.text(),
+			Link:      atomAlternate(e.Links),
+			Summary:   e.Summary.text(),
+			Content:   e.Content.text(),
+			Published: parseFeedDate(e.Published),
+			Updated:   parseFeedDate(e.Updated),
+		}
+		if len(e.Authors) > 0 {
+			item.Author = strings.TrimSpace(e.Authors[0].Name)
+		}
+		for _, c := range e.Categories {
+			item.Categories = append(item.Categories, c.Term)
+		}
+		if item.Published.IsZero() {
+			item.Published = item.Updated
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed
+}
+
+type jsonFeed struct {
+	Title       string `json:"title"`
+	HomePageURL string `json:"home_page_url"`
+	Items       []struct {
+		ID            json.RawMessage  `json:"id"`
+		URL           string           `json:"url"`
+		Title         string           `json:"title"`
+		ContentHTML   string           `json:"content_html"`
+		ContentText   string           `json:"content_text"`
+		Summary       string           `json:"summary"`
+		DatePublished string           `json:"date_published"`
+		DateModified  string           `json:"date_modified"`
+		Tags          []string         `json:"tags"`
+		Author        *jsonFeedAuthor  `json:"author"`
+		Authors       []jsonFeedAuthor `json:"authors"`
+	} `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+func parseJSONFeed(data []byte) (*Feed, error) {
+	var doc jsonFeed
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	feed := &Feed{Title: doc.Title, Link: doc.HomePageURL}
+	for _, it := range doc.Items {
+		item := FeedItem{
+			GUID:       jsonFeedID(it.ID),
+			Title:      it.Title,
+			Link:       it.URL,
+			Summary:    it.Summary,
+			Content:    firstNonEmpty(it.ContentHTML, it.ContentText),
+			Categories: it.Tags,
+			Published:  parseFeedDate(it.DatePublished),
+			Updated:    parseFeedDate(it.DateModified),
+		}
+		if len(it.Authors) > 0 {
+			item.Author = it.Authors[0].Name
+		} else if it.Author != nil {
+			item.Author = it.Author.Name
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
+
+// jsonFeedID returns the id of a JSON Feed item, which early versions of the format allowed to be a number.
+func jsonFeedID(raw json.RawMessage) string {
+	var id interface{}
+	if err := json.Unmarshal(raw, &id); err != nil || id == nil {
+		return ""
+	}
+	return fmt.Sprint(id)
+}
+
+// feedLayouts are the date formats found in feeds, RFC 822 variants for RSS and RFC 3339 for the others.
+var feedLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	"2006-01-02",
+}
+
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package spider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rssFixture = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+	<title>Blog</title>
+	<link>http://example.com/</link>
+	<item>
+		<guid>post-1</guid>
+		<title>First</title>
+		<link>http://example.com/1</link>
+		<description>Summary</description>
+		<content:encoded><![CDATA[<p>Content</p>]]></content:encoded>
+		<dc:creator>Gopher</dc:creator>
+		<category>go</category>
+		<pubDate>Mon, 02 Jan 2006 15:04:05 +0000</pubDate>
+	</item>
+	<item>
+		<title>Second</title>
+		<link>http://example.com/2</link>
+	</item>
+</channel>
+</rss>`
+
+const atomFixture = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Blog</title>
+	<link href="http://example.com/"/>
+	<entry>
+		<id>urn:post:1</id>
+		<title type="html">First &amp;lt;3</title>
+		<link rel="alternate" href="http://example.com/1"/>
+		<updated>2006-01-02T15:04:05Z</updated>
+		<author><name>Gopher</name></author>
+		<category term="go"/>
+		<summary>Summary</summary>
+	</entry>
+</feed>`
+
+const jsonFeedFixture = `{
+	"version": "https://jsonfeed.org/version/1.1",
+	"title": "Blog",
+	"home_page_url": "http://example.com/",
+	"items": [
+		{"id": 1, "url": "http://example.com/1", "title": "First", "content_html": "<p>Content</p>", "date_published": "2006-01-02T15:04:05Z", "authors": [{"name": "Gopher"}]}
+	]
+}`
+
+func TestParseFeed(t *testing.T) {
+	published := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	for name, fixture := range map[string]string{"rss": rssFixture, "atom": atomFixture, "json": jsonFeedFixture} {
+		feed, err := ParseFeed(strings.NewReader(fixture))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if feed.Title != "Blog" || feed.Link != "http://example.com/" || len(feed.Items) == 0 {
+			t.Errorf("%s: unexpected feed %+v", name, feed)
+			continue
+		}
+		item := feed.Items[0]
+		if item.GUID == "" || item.Link != "http://example.com/1" || item.Author != "Gopher" || !item.Published.Equal(published) {
+			t.Errorf("%s: unexpected item %+v", name, item)
+		}
+	}
+
+	feed, _ := ParseFeed(strings.NewReader(rssFixture))
+	if item := feed.Items[0]; item.Content != "<p>Content</p>" || item.Summary != "Summary" || item.Categories[0] != "go" {
+		t.Errorf("Unexpected RSS item %+v", item)
+	}
+	if feed.Items[1].GUID != "http://example.com/2" {
+		t.Errorf("Expected the link to be used as GUID, got %q", feed.Items[1].GUID)
+	}
+	feed, _ = ParseFeed(strings.NewReader(atomFixture))
+	if item := feed.Items[0]; item.Title != "First &lt;3" || item.GUID != "urn:post:1" {
+		t.Errorf("Unexpected Atom item %+v", item)
+	}
+	feed, _ = ParseFeed(strings.NewReader(jsonFeedFixture))
+	if feed.Items[0].GUID != "1" {
+		t.Errorf("Expected a numeric id to be converted, got %q", feed.Items[0].GUID)
+	}
+
+	if _, err := ParseFeed(strings.NewReader("<html></html>")); err != ErrUnknownFeedFormat {
+		t.Errorf("Expected ErrUnknownFeedFormat but got %v", err)
+	}
+}
+
+func TestFeedSpiderOnlyNewItems(t *testing.T) {
+	items := []string{"a", "b"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<rss version="2.0"><channel><title>Feed</title>`)
+		for _, guid := range items {
+			fmt.Fprintf(w, "<item><guid>%s</guid></item>", guid)
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	var delivered []string
+	fail := false
+	s := NewFeedSpider(ts.URL, func(ctx *Context, item FeedItem) error {
+		if fail {
+			return fmt.Errorf("failed")
+		}
+		delivered = append(delivered, item.GUID)
+		return nil
+	})
+	sched := NewScheduler()
+	e := &Entry{Spider: s}
+
+	sched.runEntry(e, nil)
+	items = []string{"c", "a", "b"}
+	fail = true
+	sched.runEntry(e, nil)
+	fail = false
+	sched.runEntry(e, nil)
+	sched.runEntry(e, nil)
+
+	if strings.Join(delivered, ",") != "a,b,c" {
+		t.Errorf("Expected each item to be delivered once, got %v", delivered)
+	}
+}
+
+func TestFeedSpiderMaxSeen(t *testing.T) {
+	items := []string{"c", "b", "a"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<rss version="2.0"><channel><title>Feed</title>`)
+		for _, guid := range items {
+			fmt.Fprintf(w, "<item><guid>%s</guid></item>", guid)
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	var delivered []string
+	s := NewFeedSpider(ts.URL, func(ctx *Context, item FeedItem) error {
+		delivered = append(delivered, item.GUID)
+		return nil
+	})
+	s.MaxSeen = 2
+	sched := NewScheduler()
+	e := &Entry{Spider: s}
+
+	sched.runEntry(e, nil)
+	sched.runEntry(e, nil)
+	items = []string{"e", "d", "c"}
+	sched.runEntry(e, nil)
+	sched.runEntry(e, nil)
+
+	if strings.Join(delivered, ",") != "c,b,a,e,d" {
+		t.Errorf("Expected each item to be delivered once, got %v", delivered)
+	}
+	seen, _ := e.state().Get(s.seenKey())
+	if strings.Join(seen.([]string), ",") != "e,d,c" {
+		t.Errorf("Expected only the GUIDs of the feed to be remembered, got %v", seen)
+	}
+}
+
+func TestFeedSpidersSharingState(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<rss version="2.0"><channel><title>Feed</title><item><guid>a</guid></item></channel></rss>`)
+	}))
+	defer ts.Close()
+
+	var delivered []string
+	fn := func(ctx *Context, item FeedItem) error {
+		delivered = append(delivered, ctx.Request().URL.Path+" "+item.GUID)
+		return nil
+	}
+	state := NewKVStore()
+	sched := NewScheduler()
+	sched.runEntry(&Entry{Spider: NewFeedSpider(ts.URL+"/first", fn), State: state}, nil)
+	sched.runEntry(&Entry{Spider: NewFeedSpider(ts.URL+"/second", fn), State: state}, nil)
+	if strings.Join(delivered, ",") != "/first a,/second a" {
+		t.Errorf("Expected each feed to remember its own items, got %v", delivered)
+	}
+}
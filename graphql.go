@@ -0,0 +1,185 @@
+package spider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/bitly/go-simplejson"
+)
+
+var (
+	ErrRepeatedCursor = errors.New("GraphQL endCursor already returned by a previous page")
+)
+
+// GraphQLError is an error of the errors array of a GraphQL response.
+type GraphQLError struct {
+	Message   string `json:"message"`
+	Locations []struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *GraphQLError) Error() string {
+	return "GraphQL: " + e.Message
+}
+
+// GraphQLErrors are the errors of a GraphQL response.
+// Use errors.As to get them from the error returned by GraphQLData.
+type GraphQLErrors []*GraphQLError
+
+func (errs GraphQLErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+	}
+	return "GraphQL: " + strings.Join(messages, "; ")
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors GraphQLErrors   `json:"errors"`
+}
+
+// GraphQL returns a new HTTP Spider posting a GraphQL query with its variables as JSON.
+// Use GraphQLData in fn to read the data of the response.
+func GraphQL(url, query string, variables map[string]interface{}, fn spinFunc, opts ...Option) *spiderFunc {
+	body := JSONBody(&graphQLRequest{Query: query, Variables: variables})
+	s := NewHTTPSpider("POST", url, nil, fn, opts...)
+	s.body = body
+	return s
+}
+
+// GraphQLData returns the data of a GraphQL response, making the request if it has not been made yet.
+//
+// If the response has errors, they are returned as GraphQLErrors along with the partial data.
+// A response with a non-2xx status returns an error, its GraphQLErrors if it has some.
+func (c *Context) GraphQLData() (*simplejson.Json, error) {
+	if c.Response() == nil {
+		if _, err := c.DoRequest(); err != nil {
+			return nil, err
+		}
+	}
+	status := c.Response().StatusCode
+	content, err := c.RAWContent()
+	if err != nil {
+		return nil, err
+	}
+	var res graphQLResponse
+	err = json.Unmarshal(content, &res)
+	if status/100 != 2 && (err != nil || len(res.Errors) == 0) {
+		return nil, fmt.Errorf("GraphQL %s: %s", c.Request().URL, c.Response().Status)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := simplejson.New()
+	if len(res.Data) > 0 && string(res.Data) != "null" {
+		if data, err = simplejson.NewJson(res.Data); err != nil {
+			return nil, err
+		}
+	}
+	if len(res.Errors) > 0 {
+		return data, res.Errors
+	}
+	return data, nil
+}
+
+// GraphQLCursor describes the Relay-style cursor pagination of a GraphQL query.
+type GraphQLCursor struct {
+	// Variable is the variable of the query receiving the cursor, "after" for instance.
+	Variable string
+	// PageInfo is the path in the data of the object holding hasNextPage and endCursor.
+	PageInfo []string
+	// MaxPages stops the pagination after this number of pages. Zero means no limit.
+	MaxPages int
+}
+
+// GraphQLPaginate calls fn with the data of the GraphQL query of this context and of its following pages.
+//
+// Each following page is requested by a child Context with the endCursor of the previous page
+// set to the cursor variable. The pagination stops when hasNextPage is false, when MaxPages is reached
+// or at the first error, GraphQLErrors included.
+// It returns ErrRepeatedCursor if a page returns the endCursor of a previous one, which would never end.
+func (c *Context) GraphQLPaginate(cursor GraphQLCursor, fn func(page int, ctx *Context, data *simplejson.Json) error) error {
+	ctx := c
+	cursors := make(map[string]bool)
+	for page := 1; ; page++ {
+		data, err := ctx.GraphQLData()
+		if err != nil {
+			return err
+		}
+		if err := fn(page, ctx, data); err != nil {
+			return err
+		}
+		if cursor.MaxPages > 0 && page >= cursor.MaxPages {
+			return nil
+		}
+		info := data.GetPath(cursor.PageInfo...)
+		next, _ := info.Get("hasNextPage").Bool()
+		end, _ := info.Get("endCursor").String()
+		if !next || end == "" {
+			return nil
+		}
+		if cursors[end] {
+			return ErrRepeatedCursor
+		}
+		cursors[end] = true
+
+		req, err := ctx.nextGraphQLRequest(cursor.Variable, end)
+		if err != nil {
+			return err
+		}
+		ctx = c.Extend(req)
+	}
+}
+
+// nextGraphQLRequest returns a copy of the GraphQL request of the context with the cursor variable set to cursor.
+func (c *Context) nextGraphQLRequest(variable, cursor string) (*http.Request, error) {
+	req := c.Request()
+	if req.GetBody == nil {
+		return nil, ErrNotReplayable
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var query graphQLRequest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&query); err != nil {
+		return nil, err
+	}
+	if query.Variables == nil {
+		query.Variables = make(map[string]interface{})
+	}
+	query.Variables[variable] = cursor
+	if data, err = json.Marshal(&query); err != nil {
+		return nil, err
+	}
+	next, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	next.Header = req.Header.Clone()
+	next.Header.Del("Content-Length")
+	return next, nil
+}
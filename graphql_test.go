@@ -0,0 +1,127 @@
+package spider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+)
+
+func graphQLServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Unexpected Content-Type %q", ct)
+		}
+		if r.URL.Path == "/down" {
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+			return
+		}
+		var req graphQLRequest
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		if first := req.Variables["first"]; first != nil && first != json.Number("9007199254740993") {
+			t.Errorf("Expected the variables to be sent unchanged, got first %v", first)
+		}
+		if req.Query == "{ broken }" {
+			fmt.Fprint(w, `{"data":null,"errors":[{"message":"Cannot query field broken","locations":[{"line":1,"column":3}]}]}`)
+			return
+		}
+		if req.Query == "{ loop }" {
+			fmt.Fprint(w, `{"data":{"items":{"nodes":[1],"pageInfo":{"hasNextPage":true,"endCursor":"same"}}}}`)
+			return
+		}
+		after, _ := req.Variables["after"].(string)
+		pages := map[string]string{
+			"":   `{"data":{"items":{"nodes":[1,2],"pageInfo":{"hasNextPage":true,"endCursor":"c2"}}}}`,
+			"c2": `{"data":{"items":{"nodes":[3],"pageInfo":{"hasNextPage":false,"endCursor":"c3"}}}}`,
+		}
+		fmt.Fprint(w, pages[after])
+	}))
+}
+
+func TestGraphQLSpider(t *testing.T) {
+	ts := graphQLServer(t)
+	defer ts.Close()
+
+	var nodes []int
+	s := GraphQL(ts.URL, "query($after: String) { items(after: $after) { nodes } }", map[string]interface{}{"first": int64(9007199254740993)}, func(ctx *Context) error {
+		return ctx.GraphQLPaginate(GraphQLCursor{Variable: "after", PageInfo: []string{"items", "pageInfo"}}, func(page int, ctx *Context, data *simplejson.Json) error {
+			for i := range data.GetPath("items", "nodes").MustArray() {
+				nodes = append(nodes, data.GetPath("items", "nodes").GetIndex(i).MustInt())
+			}
+			return nil
+		})
+	})
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Spin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodes) != "[1 2 3]" {
+		t.Errorf("Expected the nodes of both pages but got %v", nodes)
+	}
+	if len(ctx.Children) != 1 {
+		t.Errorf("Expected the second page to be a child context, got %d children", len(ctx.Children))
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	ts := graphQLServer(t)
+	defer ts.Close()
+
+	s := GraphQL(ts.URL, "{ broken }", nil, nil)
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctx.GraphQLData()
+	var errs GraphQLErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Message != "Cannot query field broken" || errs[0].Locations[0].Column != 3 {
+		t.Errorf("Expected typed GraphQL errors but got %v", err)
+	}
+}
+
+func TestGraphQLHTTPStatus(t *testing.T) {
+	ts := graphQLServer(t)
+	defer ts.Close()
+
+	s := GraphQL(ts.URL+"/down", "{ items }", nil, nil)
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.GraphQLData(); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Expected an error for the 502 status but got %v", err)
+	}
+}
+
+func TestGraphQLPaginateRepeatedCursor(t *testing.T) {
+	ts := graphQLServer(t)
+	defer ts.Close()
+
+	s := GraphQL(ts.URL, "{ loop }", nil, nil)
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.GraphQLPaginate(GraphQLCursor{Variable: "after", PageInfo: []string{"items", "pageInfo"}}, func(page int, ctx *Context, data *simplejson.Json) error {
+		if page > 10 {
+			return errors.New("the pagination did not stop")
+		}
+		return nil
+	})
+	if err != ErrRepeatedCursor {
+		t.Errorf("Expected ErrRepeatedCursor but got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package spider
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// HeaderProfile is a set of headers mimicking a browser.
+// The User-Agent is part of the headers so that it matches the other ones (Sec-CH-UA, etc.).
+//
+// Accept-Encoding is intentionally left out to keep the transparent decompression of net/http.
+type HeaderProfile struct {
+	Name   string
+	Header http.Header
+}
+
+// UserAgent returns the User-Agent of the profile.
+func (p HeaderProfile) UserAgent() string {
+	return p.Header.Get("User-Agent")
+}
+
+var (
+	// ChromeWindows mimics Chrome on Windows 10.
+	ChromeWindows = HeaderProfile{
+		Name: "chrome-windows",
+		Header: http.Header{
+			"User-Agent":                {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"},
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8"},
+			"Accept-Language":           {"en-US,en;q=0.9"},
+			"Sec-Ch-Ua":                 {`"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`},
+			"Sec-Ch-Ua-Mobile":          {"?0"},
+			"Sec-Ch-Ua-Platform":        {`"Windows"`},
+			"Sec-Fetch-Dest":            {"document"},
+			"Sec-Fetch-Mode":            {"navigate"},
+			"Sec-Fetch-Site":            {"none"},
+			"Sec-Fetch-User":            {"?1"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+	}
+	// ChromeAndroid mimics Chrome on an Android phone.
+	ChromeAndroid = HeaderProfile{
+		Name: "chrome-android",
+		Header: http.Header{
+			"User-Agent":                {"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"},
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8"},
+			"Accept-Language":           {"en-US,en;q=0.9"},
+			"Sec-Ch-Ua":                 {`"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`},
+			"Sec-Ch-Ua-Mobile":          {"?1"},
+			"Sec-Ch-Ua-Platform":        {`"Android"`},
+			"Sec-Fetch-Dest":            {"document"},
+			"Sec-Fetch-Mode":            {"navigate"},
+			"Sec-Fetch-Site":            {"none"},
+			"Sec-Fetch-User":            {"?1"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+	}
+	// FirefoxWindows mimics Firefox on Windows 10.
+	FirefoxWindows = HeaderProfile{
+		Name: "firefox-windows",
+		Header: http.Header{
+			"User-Agent":                {"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0"},
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
+			"Accept-Language":           {"en-US,en;q=0.5"},
+			"Sec-Fetch-Dest":            {"document"},
+			"Sec-Fetch-Mode":            {"navigate"},
+			"Sec-Fetch-Site":            {"none"},
+			"Sec-Fetch-User":            {"?1"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+	}
+	// SafariMac mimics Safari on macOS.
+	SafariMac = HeaderProfile{
+		Name: "safari-mac",
+		Header: http.Header{
+			"User-Agent":      {"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15"},
+			"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"Accept-Language": {"en-US,en;q=0.9"},
+			"Sec-Fetch-Dest":  {"document"},
+			"Sec-Fetch-Mode":  {"navigate"},
+			"Sec-Fetch-Site":  {"none"},
+		},
+	}
+)
+
+// DefaultHeaderProfiles returns the header profiles provided by this package.
+func DefaultHeaderProfiles() []HeaderProfile {
+	return []HeaderProfile{ChromeWindows, ChromeAndroid, FirefoxWindows, SafariMac}
+}
+
+// RotationStrategy defines how a HeaderRotator picks a profile for a request.
+type RotationStrategy int
+
+const (
+	// RotateRoundRobin uses the profiles one after the other.
+	RotateRoundRobin RotationStrategy = iota
+	// RotateRandom uses a random profile for each request.
+	RotateRandom
+	// PinPerHost always uses the same profile for a given host.
+	PinPerHost
+)
+
+// DefaultMaxPinnedHosts is the number of hosts remembered by the PinPerHost strategy.
+const DefaultMaxPinnedHosts = 1024
+
+// HeaderRotator picks a HeaderProfile for each request.
+// With PinPerHost, at most MaxPinnedHosts hosts are remembered: the oldest ones are forgotten first.
+type HeaderRotator struct {
+	MaxPinnedHosts int
+
+	mu          sync.Mutex
+	strategy    RotationStrategy
+	profiles    []HeaderProfile
+	next        int
+	pinned      map[string]HeaderProfile
+	pinnedHosts []string
+}
+
+// NewHeaderRotator returns a new HeaderRotator.
+// It uses the DefaultHeaderProfiles if no profile is provided.
+func NewHeaderRotator(strategy RotationStrategy, profiles ...HeaderProfile) *HeaderRotator {
+	if len(profiles) == 0 {
+		profiles = DefaultHeaderProfiles()
+	}
+	return &HeaderRotator{
+		MaxPinnedHosts: DefaultMaxPinnedHosts,
+		strategy:       strategy,
+		profiles:       profiles,
+		pinned:         make(map[string]HeaderProfile),
+	}
+}
+
+// Pick returns the profile to use for the provided host.
+func (r *HeaderRotator) Pick(host string) HeaderProfile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.strategy {
+	case RotateRandom:
+		return r.profiles[rand.Intn(len(r.profiles))]
+	case PinPerHost:
+		if p, ok := r.pinned[host]; ok {
+			return p
+		}
+		p := r.profiles[rand.Intn(len(r.profiles))]
+		r.pin(host, p)
+		return p
+	default:
+		p := r.profiles[r.next%len(r.profiles)]
+		r.next++
+		return p
+	}
+}
+
+// Pin forces the profile used for a host.
+func (r *HeaderRotator) Pin(host string, profile HeaderProfile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pin(host, profile)
+}
+
+// pin remembers the profile of host, forgetting the oldest hosts beyond MaxPinnedHosts.
+func (r *HeaderRotator) pin(host string, profile HeaderProfile) {
+	if _, ok := r.pinned[host]; !ok {
+		r.pinnedHosts = append(r.pinnedHosts, host)
+	}
+	r.pinned[host] = profile
+	for r.MaxPinnedHosts > 0 && len(r.pinnedHosts) > r.MaxPinnedHosts {
+		delete(r.pinned, r.pinnedHosts[0])
+		r.pinnedHosts = r.pinnedHosts[1:]
+	}
+}
+
+// Transport returns an http.RoundTripper setting the headers of a profile on every request made with base.
+//
+// The User-Agent is always replaced. The other headers of the profile are only set if they are
+// missing from the request, so headers set explicitly, like Accept for a JSON API, are kept.
+func (r *HeaderRotator) Transport(base http.RoundTripper) http.RoundTripper {
+	return &headerTransport{rotator: r, base: base}
+}
+
+type headerTransport struct {
+	rotator *HeaderRotator
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	profile := t.rotator.Pick(req.URL.Host)
+	req = req.Clone(req.Context())
+	for k, v := range profile.Header {
+		if k == "User-Agent" || len(req.Header[k]) == 0 {
+			req.Header[k] = append([]string(nil), v...)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// WithHeaderProfile sets the headers of a profile on the request.
+// It disables any HeaderRotator set before, so a spider can pin a profile
+// even if the scheduler rotates them.
+// The headers of a profile applied before are removed, unless they were overridden since.
+func WithHeaderProfile(profile HeaderProfile) Option {
+	return func(c *config) {
+		c.clearProfile()
+		c.headerRotator = nil
+		c.userAgent = profile.UserAgent()
+		c.profileHeader = make(http.Header, len(profile.Header))
+		for k, v := range profile.Header {
+			c.header[k] = append([]string(nil), v...)
+			c.profileHeader[k] = v
+		}
+	}
+}
+
+// clearProfile removes the headers set by WithHeaderProfile, unless they were overridden since.
+func (c *config) clearProfile() {
+	for k, v := range c.profileHeader {
+		if sameValues(c.header[k], v) {
+			delete(c.header, k)
+		}
+	}
+	c.profileHeader = nil
+}
+
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// WithHeaderRotation picks the headers of every request with the provided HeaderRotator.
+// The headers of a profile set before with WithHeaderProfile are removed, so they do not mix with the rotated ones.
+// A nil HeaderRotator disables the rotation.
+func WithHeaderRotation(rotator *HeaderRotator) Option {
+	return func(c *config) {
+		if rotator != nil {
+			c.clearProfile()
+		}
+		c.headerRotator = rotator
+	}
+}
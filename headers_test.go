@@ -0,0 +1,123 @@
+package spider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newHeaderEchoServer(received *[]http.Header) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*received = append(*received, r.Header)
+	}))
+}
+
+func TestHeaderRotationPerRequest(t *testing.T) {
+	var received []http.Header
+	ts := newHeaderEchoServer(&received)
+	defer ts.Close()
+
+	rotator := NewHeaderRotator(RotateRoundRobin, ChromeWindows, FirefoxWindows)
+	ctx, err := NewHTTPContext("GET", ts.URL, nil, WithHeaderRotation(rotator), WithHeaders(http.Header{"Accept": {"application/json"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ctx.DoRequest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ua := received[0].Get("User-Agent"); ua != ChromeWindows.UserAgent() {
+		t.Errorf("Expected User-Agent %q but got %q", ChromeWindows.UserAgent(), ua)
+	}
+	if ua := received[1].Get("User-Agent"); ua != FirefoxWindows.UserAgent() {
+		t.Errorf("Expected User-Agent %q but got %q", FirefoxWindows.UserAgent(), ua)
+	}
+	if platform := received[0].Get("Sec-Ch-Ua-Platform"); platform != `"Windows"` {
+		t.Errorf("Expected the Sec-CH-UA headers of the profile, got %q", platform)
+	}
+	if accept := received[1].Get("Accept"); accept != "application/json" {
+		t.Errorf("Explicit Accept header should be kept, got %q", accept)
+	}
+}
+
+func TestHeaderRotationPinPerHost(t *testing.T) {
+	rotator := NewHeaderRotator(PinPerHost)
+	first := rotator.Pick("example.com")
+	for i := 0; i < 10; i++ {
+		if p := rotator.Pick("example.com"); p.Name != first.Name {
+			t.Errorf("Expected pinned profile %q but got %q", first.Name, p.Name)
+		}
+	}
+	rotator.Pin("example.org", SafariMac)
+	if p := rotator.Pick("example.org"); p.Name != SafariMac.Name {
+		t.Errorf("Expected pinned profile %q but got %q", SafariMac.Name, p.Name)
+	}
+}
+
+func TestHeaderProfileOverridesSchedulerRotation(t *testing.T) {
+	var received []http.Header
+	ts := newHeaderEchoServer(&received)
+	defer ts.Close()
+
+	parent := NewContext().withDefaults([]Option{WithHeaderRotation(NewHeaderRotator(RotateRandom))})
+	s := Get(ts.URL, func(ctx *Context) error {
+		_, err := ctx.DoRequest()
+		return err
+	}, WithHeaderProfile(SafariMac))
+	ctx, err := s.Setup(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Spin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ua := received[0].Get("User-Agent"); ua != SafariMac.UserAgent() {
+		t.Errorf("Expected User-Agent %q but got %q", SafariMac.UserAgent(), ua)
+	}
+}
+
+func TestHeaderProfileReplacesPreviousProfile(t *testing.T) {
+	cfg := newConfig([]Option{
+		WithHeaderProfile(ChromeWindows),
+		WithHeaders(http.Header{"Accept-Language": {"fr-FR"}}),
+		WithHeaderProfile(SafariMac),
+	})
+	if ua := cfg.header.Get("Sec-Ch-Ua"); ua != "" {
+		t.Errorf("Expected the Chrome headers to be removed, got Sec-Ch-Ua %q", ua)
+	}
+	if v := cfg.header.Get("Upgrade-Insecure-Requests"); v != "" {
+		t.Errorf("Expected the Chrome headers to be removed, got Upgrade-Insecure-Requests %q", v)
+	}
+	if lang := cfg.header.Get("Accept-Language"); lang != SafariMac.Header.Get("Accept-Language") {
+		t.Errorf("Expected the Safari Accept-Language but got %q", lang)
+	}
+	if cfg.userAgent != SafariMac.UserAgent() {
+		t.Errorf("Expected User-Agent %q but got %q", SafariMac.UserAgent(), cfg.userAgent)
+	}
+}
+
+func TestHeaderRotationRemovesProfile(t *testing.T) {
+	cfg := newConfig([]Option{
+		WithHeaderProfile(ChromeWindows),
+		WithHeaderRotation(NewHeaderRotator(RotateRoundRobin, FirefoxWindows)),
+	})
+	if ua := cfg.header.Get("Sec-Ch-Ua"); ua != "" {
+		t.Errorf("Expected the Chrome headers to be removed, got Sec-Ch-Ua %q", ua)
+	}
+}
+
+func TestHeaderRotatorMaxPinnedHosts(t *testing.T) {
+	rotator := NewHeaderRotator(PinPerHost)
+	rotator.MaxPinnedHosts = 2
+	for _, host := range []string{"a", "b", "c"} {
+		rotator.Pick(host)
+	}
+	if len(rotator.pinned) != 2 {
+		t.Errorf("Expected 2 pinned hosts but got %v", rotator.pinnedHosts)
+	}
+	if _, ok := rotator.pinned["a"]; ok {
+		t.Error("Expected the oldest host to be forgotten")
+	}
+}
@@ -7,42 +7,69 @@ type spinFunc func(*Context) error
 type spiderFunc struct {
 	method string
 	url    string
-	body   io.Reader
+	body   Body
 	fn     spinFunc
+	opts   []Option
 }
 
 func (s *spiderFunc) Setup(parent *Context) (*Context, error) {
-	return NewHTTPContext(s.method, s.url, s.body)
+	var opts []Option
+	if parent != nil {
+		opts = parent.Options()
+	}
+	return newBodyContext(s.method, s.url, s.body, opts, s.opts)
 }
 func (s *spiderFunc) Spin(ctx *Context) error { return s.fn(ctx) }
 
+// newBodyContext returns a new Context for a request sending body, with the inherited options followed by opts.
+// The body is only applied to the request: it is not part of the options of the context,
+// so the spiders set up with it as parent do not send it.
+func newBodyContext(method, url string, body Body, inherited, opts []Option) (*Context, error) {
+	options := append(inherited, opts...)
+	if body == nil {
+		return NewHTTPContext(method, url, nil, options...)
+	}
+	withBody := append(append(append([]Option(nil), inherited...), WithBody(body)), opts...)
+	ctx, err := NewHTTPContext(method, url, nil, withBody...)
+	ctx.SetOptions(options...)
+	return ctx, err
+}
+
 // NewHTTPSpider creates a new spider according to the http method, url and body.
-// The last argument is a closure for doing the actual work
-func NewHTTPSpider(method, url string, body io.Reader, fn spinFunc) *spiderFunc {
-	return &spiderFunc{
+// The fourth argument is a closure for doing the actual work.
+// The options are applied after the ones of the parent Context passed to Setup.
+//
+// The body is read entirely on the first Setup and a copy of it is sent by every run.
+// Use WithBody to generate it for each run instead.
+func NewHTTPSpider(method, url string, body io.Reader, fn spinFunc, opts ...Option) *spiderFunc {
+	s := &spiderFunc{
 		method: method,
 		url:    url,
-		body:   body,
 		fn:     fn,
+		opts:   opts,
+	}
+	if body != nil {
+		s.body = ReaderBody(body)
 	}
+	return s
 }
 
 // Get returns a new GET HTTP Spider.
-func Get(url string, fn spinFunc) *spiderFunc {
-	return NewHTTPSpider("GET", url, nil, fn)
+func Get(url string, fn spinFunc, opts ...Option) *spiderFunc {
+	return NewHTTPSpider("GET", url, nil, fn, opts...)
 }
 
 // Post returns a new POST HTTP Spider.
-func Post(url string, body io.Reader, fn spinFunc) *spiderFunc {
-	return NewHTTPSpider("POST", url, body, fn)
+func Post(url string, body io.Reader, fn spinFunc, opts ...Option) *spiderFunc {
+	return NewHTTPSpider("POST", url, body, fn, opts...)
 }
 
 // Put returns a new PUT HTTP Spider.
-func Put(url string, body io.Reader, fn spinFunc) *spiderFunc {
-	return NewHTTPSpider("PUT", url, body, fn)
+func Put(url string, body io.Reader, fn spinFunc, opts ...Option) *spiderFunc {
+	return NewHTTPSpider("PUT", url, body, fn, opts...)
 }
 
 // Delete returns a new DELETE HTTP Spider.
-func Delete(url string, fn spinFunc) *spiderFunc {
-	return NewHTTPSpider("DELETE", url, nil, fn)
+func Delete(url string, fn spinFunc, opts ...Option) *spiderFunc {
+	return NewHTTPSpider("DELETE", url, nil, fn, opts...)
 }
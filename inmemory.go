@@ -1,33 +1,69 @@
 package spider
 
 import (
+	"errors"
+	"fmt"
 	"sort"
+	"sync"
 	"time"
+
+	"github.com/cenkalti/backoff"
 )
 
 // InMemory is the default scheduler
 type InMemory struct {
-	entries Entries
-	addCh   chan *Entry
-	stopCh  chan struct{}
-	running bool
+	entries  Entries
+	alwaysOn []*Entry
+	addCh    chan *Entry
+	stopCh   chan struct{}
+	done     chan struct{}
+	running  bool
+	options  []Option
+	onChange func(*Entry, *Context, *Change)
+
+	hooksMu sync.RWMutex
+	hooks   []func(Event)
+	watched map[*CircuitBreaker]bool
 }
 
-// NewScheduler returns a new InMemory scheduler
-func NewScheduler() *InMemory {
-	return &InMemory{
+// NewScheduler returns a new InMemory scheduler.
+// The options are scheduler-wide defaults: they are passed to the Setup of every spider
+// through its root Context and are applied before the spider's own options.
+func NewScheduler(opts ...Option) *InMemory {
+	in := &InMemory{
 		addCh:   make(chan *Entry),
 		stopCh:  make(chan struct{}),
 		entries: nil,
+		options: opts,
+		watched: make(map[*CircuitBreaker]bool),
 	}
+	if cb := newConfig(opts).breaker; cb != nil {
+		in.WatchCircuitBreaker(cb)
+	}
+	return in
 }
 
-// Entry groups a spider, its root context, a Schedule and the Next time the spider must be launched
+// Entry groups a spider, its root context, a Schedule and the Next time the spider must be launched.
+// Retry optionally configures how its failed runs are retried.
+// Breaker optionally skips its runs after consecutive failures, using Name as the key of its circuit,
+// or a key unique to the entry if Name is empty.
+// State stores the values set by the runs in Context.State; it is kept in memory if nil.
+// They are committed when a run succeeds, and whenever a run of an AlwaysOn entry ends.
+// AlwaysOn entries have no Schedule: they are run as soon as the scheduler starts and run again when they end,
+// see InMemory.AddAlwaysOn.
 type Entry struct {
+	Name     string
 	Spider   Spider
 	Schedule Schedule
 	Ctx      *Context
 	Next     time.Time
+	Retry    *RunRetry
+	Breaker  *CircuitBreaker
+	State    Store
+	AlwaysOn bool
+
+	mu      sync.Mutex
+	history []Run
 }
 
 // Entries is a collection of Entry.
@@ -53,22 +89,45 @@ func (in *InMemory) Add(sched Schedule, spider Spider) {
 
 // AddWithCtx adds a spider with a root Context passed in the arguments
 func (in *InMemory) AddWithCtx(sched Schedule, spider Spider, ctx *Context) {
-	entry := &Entry{
+	in.AddEntry(&Entry{
 		Spider:   spider,
 		Schedule: sched,
 		Ctx:      ctx,
+	})
+}
+
+// AddEntry adds an entry, which allows to set its RunRetry and CircuitBreaker.
+// Spider and Schedule must be set, unless the entry is AlwaysOn.
+func (in *InMemory) AddEntry(entry *Entry) {
+	if entry.Breaker != nil {
+		in.WatchCircuitBreaker(entry.Breaker)
 	}
 	if !in.running {
-		in.entries = append(in.entries, entry)
+		if entry.AlwaysOn {
+			in.alwaysOn = append(in.alwaysOn, entry)
+		} else {
+			in.entries = append(in.entries, entry)
+		}
 		return
 	}
 	in.addCh <- entry
 }
 
+// AddAlwaysOn adds a long-lived spider, such as a stream spider, which is not scheduled but supervised:
+// it is run when the scheduler starts and run again each time its Spin returns, until Stop.
+// The restarts wait according to an exponential backoff, reset after a run lasting more than AlwaysOnResetAfter.
+// Use AddEntry with an AlwaysOn entry to configure the backoff with a RunRetry.
+func (in *InMemory) AddAlwaysOn(spider Spider) {
+	in.AddEntry(&Entry{
+		Spider:   spider,
+		AlwaysOn: true,
+	})
+}
+
 // AddFunc allows to add a spider using an url and a closure.
 // It is by default using the GET HTTP method.
-func (in *InMemory) AddFunc(sched Schedule, url string, fn func(*Context) error) {
-	s := Get(url, fn)
+func (in *InMemory) AddFunc(sched Schedule, url string, fn func(*Context) error, opts ...Option) {
+	s := Get(url, fn, opts...)
 	in.AddWithCtx(sched, s, nil)
 }
 
@@ -77,11 +136,16 @@ func (in *InMemory) AddFunc(sched Schedule, url string, fn func(*Context) error)
 // Your code will continue to be execute after calling this function.
 func (in *InMemory) Start() {
 	in.running = true
+	in.done = make(chan struct{})
 	go in.start()
 }
 
 func (in *InMemory) start() {
+	done := in.done
 	now := time.Now().Local()
+	for _, e := range in.alwaysOn {
+		go in.supervise(e, done)
+	}
 	for _, e := range in.entries {
 		e.Next = e.Schedule.Next(now)
 	}
@@ -102,11 +166,16 @@ func (in *InMemory) start() {
 				if e.Next != nextRun {
 					break
 				}
-				go in.runEntry(e)
+				go in.runEntry(e, done)
 				e.Next = e.Schedule.Next(nextRun)
 			}
 			continue
 		case e := <-in.addCh:
+			if e.AlwaysOn {
+				in.alwaysOn = append(in.alwaysOn, e)
+				go in.supervise(e, done)
+				break
+			}
 			in.entries = append(in.entries, e)
 			e.Next = e.Schedule.Next(now)
 		case <-in.stopCh:
@@ -116,18 +185,150 @@ func (in *InMemory) start() {
 	}
 }
 
-func (in *InMemory) runEntry(e *Entry) {
-	ctx, _ := e.Spider.Setup(e.Ctx)
-	e.Spider.Spin(ctx)
+// runEntry runs an entry and retries it according to its RunRetry until it succeeds.
+// Pending retries are abandoned when done is closed.
+func (in *InMemory) runEntry(e *Entry, done <-chan struct{}) {
+	var b backoff.BackOff
+	for attempt := 1; ; attempt++ {
+		run := Run{Attempt: attempt, Start: time.Now()}
+		ctx, err := in.runOnce(e, done)
+		run.End = time.Now()
+		run.Err = err
+		e.record(run)
+		in.emit(Event{Type: EventRun, Time: run.End, Entry: e, Run: run})
+		if err == nil {
+			in.notifyChange(e, ctx)
+			return
+		}
+
+		if errors.Is(err, ErrCircuitOpen) || e.Retry == nil || attempt >= e.Retry.MaxAttempts {
+			return
+		}
+		if b == nil {
+			b = e.Retry.newBackOff()
+			b.Reset()
+		}
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return
+		}
+		select {
+		case <-time.After(e.Retry.jitter(wait)):
+		case <-done:
+			return
+		}
+	}
+}
+
+// supervise runs an always-on entry again each time it ends, until done is closed.
+// The restarts wait according to the NewBackOff of the RunRetry of the entry, or an exponential backoff without limit.
+func (in *InMemory) supervise(e *Entry, done <-chan struct{}) {
+	var b backoff.BackOff
+	if e.Retry != nil && e.Retry.NewBackOff != nil {
+		b = e.Retry.NewBackOff()
+	} else {
+		exp := backoff.NewExponentialBackOff()
+		exp.MaxElapsedTime = 0
+		b = exp
+	}
+	b.Reset()
+	for attempt := 1; ; attempt++ {
+		run := Run{Attempt: attempt, Start: time.Now()}
+		_, err := in.runOnce(e, done)
+		run.End = time.Now()
+		run.Err = err
+		e.record(run)
+		in.emit(Event{Type: EventRun, Time: run.End, Entry: e, Run: run})
+
+		if run.End.Sub(run.Start) > AlwaysOnResetAfter {
+			b.Reset()
+		}
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return
+		}
+		if e.Retry != nil {
+			wait = e.Retry.jitter(wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-done:
+			return
+		}
+	}
+}
+
+func (in *InMemory) runOnce(e *Entry, done <-chan struct{}) (*Context, error) {
+	if e.Breaker != nil {
+		if err := e.Breaker.Allow(e.circuitKey()); err != nil {
+			return nil, err
+		}
+	}
+	state := loadState(e.state())
+	ctx, err := e.Spider.Setup(e.Ctx.withDefaults(in.options))
+	if err == nil {
+		if ctx != nil {
+			ctx.state = state
+			ctx.done = done
+		}
+		err = e.Spider.Spin(ctx)
+	}
+	// An AlwaysOn entry ends with its stream: the events it handled are kept for the next connection.
+	if err == nil || e.AlwaysOn {
+		if cerr := state.commit(); cerr != nil {
+			err = cerr
+		}
+	}
+	if e.Breaker != nil {
+		e.Breaker.Report(e.circuitKey(), err != nil)
+	}
+	return ctx, err
+}
+
+// circuitKey returns the key of the entry in its CircuitBreaker.
+func (e *Entry) circuitKey() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return fmt.Sprintf("entry-%p", e)
+}
+
+func (in *InMemory) notifyChange(e *Entry, ctx *Context) {
+	if ctx == nil || ctx.changeDetector == nil || ctx.Response() == nil {
+		return
+	}
+	change, err := ctx.Changed()
+	if err != nil || !change.Changed || change.First {
+		return
+	}
+	if in.onChange != nil {
+		in.onChange(e, ctx, change)
+	}
+	in.emit(Event{Type: EventChange, Entry: e, Change: change})
+}
+
+// OnChange registers a callback fired after a successful run whose monitored content
+// differs from the one of the previous run.
+// Change detection must be enabled on the spider with WithChangeDetection.
+// It should be called before Start.
+func (in *InMemory) OnChange(fn func(e *Entry, ctx *Context, change *Change)) {
+	in.onChange = fn
 }
 
 // Stop the scheduler.
 // Should be called after Start.
+// The Renderer passed to NewScheduler with WithRenderer is closed if it implements io.Closer.
 func (in *InMemory) Stop() {
 	in.stopCh <- struct{}{}
+	close(in.done)
 	in.running = false
+	in.closeRenderer()
 }
 
+// AlwaysOnResetAfter is the duration after which a run of an always-on entry is considered healthy:
+// the backoff before its next restart is then reset.
+var AlwaysOnResetAfter = time.Minute
+
 // Standard Scheduler
 var stdSched = NewScheduler()
 
@@ -137,8 +338,8 @@ func Add(sched Schedule, spider Spider) {
 }
 
 // AddFunc allows to add a spider to the standard scheduler using an url and a closure.
-func AddFunc(sched Schedule, url string, fn func(*Context) error) {
-	stdSched.AddFunc(sched, url, fn)
+func AddFunc(sched Schedule, url string, fn func(*Context) error, opts ...Option) {
+	stdSched.AddFunc(sched, url, fn, opts...)
 }
 
 // Start starts the standard scheduler
@@ -0,0 +1,134 @@
+package spider
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// effectiveURL returns the URL of the response after redirects, or the URL of the request.
+func (c *Context) effectiveURL() *url.URL {
+	if res := c.Response(); res != nil && res.Request != nil && res.Request.URL != nil {
+		return res.Request.URL
+	}
+	if req := c.Request(); req != nil {
+		return req.URL
+	}
+	return nil
+}
+
+// BaseURL returns the URL against which the links of the response are resolved:
+// the URL of the response after redirects, or the href of the <base> element of an HTML page.
+// Before the request is made, it is the URL of the request.
+func (c *Context) BaseURL() (*url.URL, error) {
+	base := c.effectiveURL()
+	if base == nil {
+		return nil, ErrNoRequest
+	}
+	res := c.Response()
+	if res == nil {
+		return base, nil
+	}
+	if c.baseOf == res {
+		return c.base, nil
+	}
+	if !c.isHTMLResponse(res) {
+		c.base, c.baseOf = base, res
+		return base, nil
+	}
+	if err := c.rewindResponse(); err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(c.body))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.rewindResponse(); err != nil {
+		return nil, err
+	}
+	return c.documentBase(doc), nil
+}
+
+// documentBase resolves the <base> of doc against the effective URL and caches it for the current response.
+func (c *Context) documentBase(doc *goquery.Document) *url.URL {
+	base := c.effectiveURL()
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok && base != nil {
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = u
+		}
+	}
+	c.base, c.baseOf = base, c.Response()
+	return base
+}
+
+// AbsURL resolves href against the BaseURL of this context.
+func (c *Context) AbsURL(href string) (*url.URL, error) {
+	base, err := c.BaseURL()
+	if err != nil {
+		return nil, err
+	}
+	return base.Parse(strings.TrimSpace(href))
+}
+
+// AbsLinks returns the values of attr of the elements of s resolved against the BaseURL of this context.
+// Only http and https links are kept: empty values, fragments of the page, and mailto:, tel:, data:
+// or javascript: links are skipped.
+func (c *Context) AbsLinks(s *goquery.Selection, attr string) []string {
+	base, err := c.BaseURL()
+	if err != nil {
+		return nil
+	}
+	var links []string
+	s.Each(func(_ int, el *goquery.Selection) {
+		href := strings.TrimSpace(el.AttrOr(attr, ""))
+		if href == "" || strings.HasPrefix(href, "#") {
+			return
+		}
+		if u, err := base.Parse(href); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			links = append(links, u.String())
+		}
+	})
+	return links
+}
+
+// isHTMLResponse reports whether res is an HTML page.
+// Without Content-Type, the type is sniffed from the first bytes of the body, which are put back.
+func (c *Context) isHTMLResponse(res *http.Response) bool {
+	contentType := res.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(c.peekBody(res))
+	}
+	return isHTML(contentType)
+}
+
+// peekBody returns the first 512 bytes of the body of res without consuming them.
+func (c *Context) peekBody(res *http.Response) []byte {
+	if c.body != nil {
+		if len(c.body) > 512 {
+			return c.body[:512]
+		}
+		return c.body
+	}
+	if res.Body == nil {
+		return nil
+	}
+	peek := make([]byte, 512)
+	n, _ := io.ReadFull(res.Body, peek)
+	res.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(peek[:n]), res.Body), Closer: res.Body}
+	return peek[:n]
+}
+
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
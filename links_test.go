@@ -0,0 +1,118 @@
+package spider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBaseURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/articles/new", http.StatusMovedPermanently)
+		case "/articles/new":
+			fmt.Fprint(w, `<html><body><a href="next">Next</a><a href="#top">Top</a><a href="javascript:void(0)">JS</a><a href="mailto:a@example.com">Mail</a><a href="tel:+123">Call</a><a href="data:text/plain,x">Data</a><a href="/about">About</a></body></html>`)
+		case "/based":
+			fmt.Fprint(w, `<html><head><base href="/static/"></head><body><a href="page">Page</a></body></html>`)
+		case "/untyped":
+			w.Header()["Content-Type"] = nil
+			fmt.Fprint(w, `<html><head><base href="/static/"></head></html>`)
+		case "/binary":
+			w.Header()["Content-Type"] = nil
+			fmt.Fprint(w, "\x89PNG\r\n\x1a\n<base href=\"/static/\">")
+		case "/data.json":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer ts.Close()
+
+	get := func(path string) *Context {
+		ctx, err := NewHTTPContext("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ctx.DoRequest(); err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+
+	ctx := get("/old")
+	if base, err := ctx.BaseURL(); err != nil || base.String() != ts.URL+"/articles/new" {
+		t.Errorf("Expected the base to follow redirects, got %v (%v)", base, err)
+	}
+	if u, _ := ctx.AbsURL("next"); u.String() != ts.URL+"/articles/next" {
+		t.Errorf("Unexpected absolute url %s", u)
+	}
+	doc, err := ctx.HTMLParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := ctx.AbsLinks(doc.Find("a"), "href")
+	if fmt.Sprint(links) != fmt.Sprint([]string{ts.URL + "/articles/next", ts.URL + "/about"}) {
+		t.Errorf("Unexpected links %v", links)
+	}
+
+	ctx = get("/based")
+	if u, _ := ctx.AbsURL("page"); u.String() != ts.URL+"/static/page" {
+		t.Errorf("Expected the <base> of the page to be honored, got %s", u)
+	}
+	doc, _ = ctx.HTMLParser()
+	if doc.Url.String() != ts.URL+"/static/" {
+		t.Errorf("Expected the document to know its base, got %s", doc.Url)
+	}
+
+	ctx = get("/untyped")
+	if u, _ := ctx.AbsURL("page"); u.String() != ts.URL+"/static/page" {
+		t.Errorf("Expected a page without Content-Type to be sniffed as HTML, got %s", u)
+	}
+	ctx = get("/binary")
+	if u, _ := ctx.AbsURL("page"); u.String() != ts.URL+"/page" {
+		t.Errorf("Expected a binary without Content-Type not to be parsed, got %s", u)
+	}
+	if body, _ := ctx.RAWContent(); !strings.HasPrefix(string(body), "\x89PNG") {
+		t.Errorf("Expected the sniffed body to be kept, got %q", body)
+	}
+
+	ctx = get("/data.json")
+	if base, _ := ctx.BaseURL(); base.String() != ts.URL+"/data.json" {
+		t.Errorf("Unexpected base %s", base)
+	}
+	if _, err := NewContext().BaseURL(); err != ErrNoRequest {
+		t.Errorf("Expected ErrNoRequest but got %v", err)
+	}
+}
+
+func TestBufferedBodyResetOnNewResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><body><img src="%s.png"></body></html>`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	ctx, err := NewHTTPContext("GET", ts.URL+"/one", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range []string{"/one", "/two"} {
+		req, _ := http.NewRequest("GET", ts.URL+page, nil)
+		ctx.SetRequest(req)
+		if _, err := ctx.DoRequest(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ctx.BaseURL(); err != nil {
+			t.Fatal(err)
+		}
+		assets, err := ctx.Assets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(assets) != 1 || assets[0].URL != ts.URL+page+".png" {
+			t.Errorf("Expected the asset of %s, got %+v", page, assets)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package spider
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultUserAgent is the User-Agent set on requests created by NewHTTPContext.
+	DefaultUserAgent = "Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 Safari/537.36"
+	// DefaultContentType is the Content-Type set on requests created by NewHTTPContext.
+	DefaultContentType = "application/json"
+)
+
+// Option configures the http.Client and the http.Request of a Context.
+//
+// Options can be passed to NewHTTPContext, NewHTTPSpider, the Get/Post/Put/Delete helpers
+// and NewScheduler. They are applied in order, so the last one wins.
+type Option func(*config)
+
+// config holds the settings resolved from a list of Option.
+type config struct {
+	userAgent      string
+	header         http.Header
+	timeout        time.Duration
+	transport      http.RoundTripper
+	proxy          func(*http.Request) (*url.URL, error)
+	tlsConfig      *tls.Config
+	keepAlive      bool
+	contentType    string
+	proxyPool      *ProxyPool
+	headerRotator  *HeaderRotator
+	profileHeader  http.Header
+	jar            http.CookieJar
+	session        *Session
+	cache          Cache
+	changeDetector *changeDetector
+	retryPolicy    *RetryPolicy
+	breaker        *CircuitBreaker
+	body           Body
+	store          Store
+	renderer       Renderer
+	renderWait     Wait
+	downloader     *Downloader
+	redirectPolicy RedirectPolicy
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		userAgent:   DefaultUserAgent,
+		header:      make(http.Header),
+		contentType: DefaultContentType,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithUserAgent sets the User-Agent header of the request.
+func WithUserAgent(ua string) Option {
+	return func(c *config) {
+		c.userAgent = ua
+	}
+}
+
+// WithHeaders adds headers to the request.
+// They are applied after the User-Agent and the Content-Type, so they can override them.
+func WithHeaders(header http.Header) Option {
+	return func(c *config) {
+		for k, v := range header {
+			c.header[k] = append([]string(nil), v...)
+		}
+	}
+}
+
+// WithTimeout sets the timeout of the http.Client.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithTransport sets the http.RoundTripper used by the http.Client.
+//
+// WithProxy and WithTLSConfig only apply when the transport is an *http.Transport.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *config) {
+		c.transport = transport
+	}
+}
+
+// WithProxy routes the requests through the proxy at the given url.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *config) {
+		c.proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of the transport.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *config) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithKeepAlive enables or disables keep-alive connections.
+// It is disabled by default.
+func WithKeepAlive(keepAlive bool) Option {
+	return func(c *config) {
+		c.keepAlive = keepAlive
+	}
+}
+
+// WithContentType sets the Content-Type header of the request.
+// An empty string removes it.
+func WithContentType(contentType string) Option {
+	return func(c *config) {
+		c.contentType = contentType
+	}
+}
+
+// roundTripper returns the transport to use with the http.Client.
+func (c *config) roundTripper() http.RoundTripper {
+	transport := c.baseRoundTripper()
+	if c.breaker != nil {
+		transport = c.breaker.Transport(transport)
+	}
+	if c.cache != nil {
+		transport = CacheTransport(c.cache, transport)
+	}
+	if c.headerRotator != nil {
+		transport = c.headerRotator.Transport(transport)
+	}
+	return transport
+}
+
+// baseRoundTripper returns the transport configured with the proxy and TLS options.
+func (c *config) baseRoundTripper() http.RoundTripper {
+	transport := c.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	t, ok := transport.(*http.Transport)
+	if !ok || (c.proxy == nil && c.tlsConfig == nil && c.proxyPool == nil) {
+		return transport
+	}
+	t = t.Clone()
+	if c.proxy != nil {
+		t.Proxy = c.proxy
+	}
+	if c.tlsConfig != nil {
+		t.TLSClientConfig = c.tlsConfig
+	}
+	if c.proxyPool != nil {
+		return c.proxyPool.Transport(t)
+	}
+	return t
+}
+
+// prepareRequest sets the headers of the request and its keep-alive behavior.
+func (c *config) prepareRequest(req *http.Request) {
+	req.Close = !c.keepAlive
+	if c.contentType != "" {
+		req.Header.Set("Content-Type", c.contentType)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for k, v := range c.header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+}
@@ -0,0 +1,96 @@
+package spider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	ctx, err := NewHTTPContext("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := ctx.Request()
+	if !req.Close {
+		t.Error("Keep-alive should be disabled by default")
+	}
+	if ua := req.Header.Get("User-Agent"); ua != DefaultUserAgent {
+		t.Errorf("Expected User-Agent %q but got %q", DefaultUserAgent, ua)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != DefaultContentType {
+		t.Errorf("Expected Content-Type %q but got %q", DefaultContentType, ct)
+	}
+	if ctx.Client.Transport != http.DefaultTransport {
+		t.Error("Expected the default transport")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	proxyURL, _ := url.Parse("http://127.0.0.1:3128")
+	ctx, err := NewHTTPContext("GET", "http://example.com", nil,
+		WithUserAgent("spider"),
+		WithContentType(""),
+		WithHeaders(http.Header{"X-Token": {"secret"}}),
+		WithTimeout(3*time.Second),
+		WithKeepAlive(true),
+		WithProxy(proxyURL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := ctx.Request()
+	if req.Close {
+		t.Error("Keep-alive should be enabled")
+	}
+	if ua := req.Header.Get("User-Agent"); ua != "spider" {
+		t.Errorf("Expected User-Agent %q but got %q", "spider", ua)
+	}
+	if _, ok := req.Header["Content-Type"]; ok {
+		t.Error("Content-Type should not be set")
+	}
+	if tok := req.Header.Get("X-Token"); tok != "secret" {
+		t.Errorf("Expected X-Token %q but got %q", "secret", tok)
+	}
+	if ctx.Client.Timeout != 3*time.Second {
+		t.Errorf("Expected timeout of %s but got %s", 3*time.Second, ctx.Client.Timeout)
+	}
+	transport, ok := ctx.Client.Transport.(*http.Transport)
+	if !ok || transport == http.DefaultTransport {
+		t.Fatal("Expected a copy of the default transport")
+	}
+	u, err := transport.Proxy(req)
+	if err != nil || u.String() != proxyURL.String() {
+		t.Errorf("Expected proxy %s but got %s", proxyURL, u)
+	}
+}
+
+func TestSpiderOptionsOverrideParent(t *testing.T) {
+	var ua string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua = r.Header.Get("User-Agent")
+	}))
+	defer ts.Close()
+
+	parent := NewContext().withDefaults([]Option{WithUserAgent("scheduler"), WithTimeout(time.Second)})
+
+	s := Get(ts.URL, func(ctx *Context) error {
+		_, err := ctx.DoRequest()
+		return err
+	}, WithUserAgent("spider"))
+	ctx, err := s.Setup(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Spin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ua != "spider" {
+		t.Errorf("Expected User-Agent %q but got %q", "spider", ua)
+	}
+	if ctx.Client.Timeout != time.Second {
+		t.Errorf("Expected timeout inherited from parent, got %s", ctx.Client.Timeout)
+	}
+}
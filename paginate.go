@@ -0,0 +1,181 @@
+package spider
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Paginator returns the URL of the page following the one of ctx, or nil if ctx is the last page.
+// The response of ctx can be parsed by the Paginator, its body is restored afterwards.
+type Paginator func(ctx *Context) (*url.URL, error)
+
+// NextLink returns a Paginator following the href attribute of the first element matching selector.
+// Relative links are resolved against the BaseURL of the current page.
+func NextLink(selector string) Paginator {
+	return func(ctx *Context) (*url.URL, error) {
+		doc, err := ctx.HTMLParser()
+		if err != nil {
+			return nil, err
+		}
+		href, ok := doc.Find(selector).First().Attr("href")
+		if !ok || strings.TrimSpace(href) == "" {
+			return nil, nil
+		}
+		return ctx.AbsURL(href)
+	}
+}
+
+// IncrementParam returns a Paginator adding step to the query parameter name of the current URL.
+// A missing or invalid parameter is considered equal to start.
+func IncrementParam(name string, start, step int) Paginator {
+	return func(ctx *Context) (*url.URL, error) {
+		u := *ctx.Request().URL
+		q := u.Query()
+		n, err := strconv.Atoi(q.Get(name))
+		if err != nil {
+			n = start
+		}
+		q.Set(name, strconv.Itoa(n+step))
+		u.RawQuery = q.Encode()
+		return &u, nil
+	}
+}
+
+// JSONCursor returns a Paginator reading a cursor at path in the JSON response
+// and setting it to the query parameter name of the current URL.
+// The pagination ends when the cursor is missing, null or empty.
+func JSONCursor(name string, path ...string) Paginator {
+	return func(ctx *Context) (*url.URL, error) {
+		js, err := ctx.JSONParser()
+		if err != nil {
+			return nil, err
+		}
+		v := js.GetPath(path...).Interface()
+		if v == nil {
+			return nil, nil
+		}
+		cursor := fmt.Sprint(v)
+		if cursor == "" {
+			return nil, nil
+		}
+		u := *ctx.Request().URL
+		q := u.Query()
+		q.Set(name, cursor)
+		u.RawQuery = q.Encode()
+		return &u, nil
+	}
+}
+
+// LinkHeader is a Paginator following the rel="next" URL of the Link header of the response.
+func LinkHeader(ctx *Context) (*url.URL, error) {
+	for _, header := range ctx.Response().Header["Link"] {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return ctx.effectiveURL().Parse(target[1 : len(target)-1])
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Pagination describes how to request the pages following the one of a Context.
+type Pagination struct {
+	Next Paginator
+	// MaxPages stops the pagination after this number of pages, the first one included.
+	// Zero means no limit.
+	MaxPages int
+	// IsEmpty stops the pagination before calling OnPage when it returns true.
+	IsEmpty func(ctx *Context) bool
+	// OnPage is called for every page, starting at 1.
+	OnPage func(page int, ctx *Context) error
+}
+
+// Paginate calls p.OnPage for the page of this context and for every following page returned by p.Next.
+//
+// The request of this context is made if it has not been made yet, and its body must not have been read.
+// Each following page gets its own child Context created with Extend.
+// The pagination stops without error on the last page, when p.MaxPages is reached,
+// when p.IsEmpty returns true or when a URL has already been visited.
+func (c *Context) Paginate(p *Pagination) error {
+	if c.Response() == nil {
+		if _, err := c.DoRequest(); err != nil {
+			return err
+		}
+	}
+	seen := map[string]bool{c.Request().URL.String(): true}
+	ctx := c
+	for page := 1; ; page++ {
+		if err := ctx.rewindResponse(); err != nil {
+			return err
+		}
+		if p.IsEmpty != nil {
+			empty := p.IsEmpty(ctx)
+			if err := ctx.rewindResponse(); err != nil {
+				return err
+			}
+			if empty {
+				return nil
+			}
+		}
+		if p.OnPage != nil {
+			if err := p.OnPage(page, ctx); err != nil {
+				return err
+			}
+			if err := ctx.rewindResponse(); err != nil {
+				return err
+			}
+		}
+		if p.MaxPages > 0 && page >= p.MaxPages {
+			return nil
+		}
+
+		next, err := p.Next(ctx)
+		if err != nil || next == nil {
+			return err
+		}
+		if seen[next.String()] {
+			return nil
+		}
+		seen[next.String()] = true
+
+		req, err := http.NewRequest("GET", next.String(), nil)
+		if err != nil {
+			return err
+		}
+		ctx = c.Extend(req)
+		if _, err := ctx.DoRequest(); err != nil {
+			return err
+		}
+	}
+}
+
+// rewindResponse buffers the body of the response the first time and restores it the next times.
+func (c *Context) rewindResponse() error {
+	res := c.Response()
+	if res == nil {
+		return ErrNoRequest
+	}
+	if c.body == nil {
+		return c.bufferBody(res)
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(c.body))
+	return nil
+}
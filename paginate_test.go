@@ -0,0 +1,100 @@
+package spider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func paginate(t *testing.T, url string, p *Pagination) []string {
+	var pages []string
+	p.OnPage = func(page int, ctx *Context) error {
+		if page != len(pages)+1 {
+			t.Errorf("Expected page %d but got %d", len(pages)+1, page)
+		}
+		pages = append(pages, ctx.Request().URL.RequestURI())
+		return nil
+	}
+	ctx, err := NewHTTPContext("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Paginate(p); err != nil {
+		t.Fatal(err)
+	}
+	return pages
+}
+
+func expectPages(t *testing.T, pages []string, expected ...string) {
+	t.Helper()
+	if fmt.Sprint(pages) != fmt.Sprint(expected) {
+		t.Errorf("Expected pages %v but got %v", expected, pages)
+	}
+}
+
+func TestPaginateNextLink(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1":
+			fmt.Fprint(w, `<a class="next" href="2">next</a>`)
+		case "/2":
+			fmt.Fprint(w, `<a class="next" href="/3">next</a>`)
+		case "/3":
+			// Links back to the first page.
+			fmt.Fprint(w, `<a class="next" href="/1">next</a>`)
+		}
+	}))
+	defer ts.Close()
+
+	pages := paginate(t, ts.URL+"/1", &Pagination{Next: NextLink("a.next")})
+	expectPages(t, pages, "/1", "/2", "/3")
+}
+
+func TestPaginateIncrementParam(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "4" {
+			return
+		}
+		fmt.Fprint(w, "results")
+	}))
+	defer ts.Close()
+
+	empty := func(ctx *Context) bool {
+		data, _ := ctx.RAWContent()
+		return len(data) == 0
+	}
+	pages := paginate(t, ts.URL+"/list", &Pagination{Next: IncrementParam("page", 1, 1), IsEmpty: empty})
+	expectPages(t, pages, "/list", "/list?page=2", "/list?page=3")
+
+	pages = paginate(t, ts.URL+"/list", &Pagination{Next: IncrementParam("page", 1, 1), MaxPages: 2})
+	expectPages(t, pages, "/list", "/list?page=2")
+}
+
+func TestPaginateJSONCursor(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		if cursor < 20 {
+			fmt.Fprintf(w, `{"meta":{"next":%d}}`, cursor+10)
+			return
+		}
+		fmt.Fprint(w, `{"meta":{"next":null}}`)
+	}))
+	defer ts.Close()
+
+	pages := paginate(t, ts.URL, &Pagination{Next: JSONCursor("cursor", "meta", "next")})
+	expectPages(t, pages, "/", "/?cursor=10", "/?cursor=20")
+}
+
+func TestPaginateLinkHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") != "2" {
+			w.Header().Set("Link", `</items?page=1>; rel="first", </items?page=2>; rel="next"`)
+		}
+	}))
+	defer ts.Close()
+
+	pages := paginate(t, ts.URL+"/items", &Pagination{Next: LinkHeader})
+	expectPages(t, pages, "/items", "/items?page=2")
+}
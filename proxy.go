@@ -0,0 +1,300 @@
+package spider
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoProxy = errors.New("No healthy proxy available")
+)
+
+// ProxyStrategy defines how a ProxyPool picks a proxy for a request.
+type ProxyStrategy int
+
+const (
+	// RoundRobin picks the proxies one after the other.
+	RoundRobin ProxyStrategy = iota
+	// RandomProxy picks a random proxy for each request.
+	RandomProxy
+	// StickyPerHost always uses the same proxy for a given host, as long as it is healthy.
+	StickyPerHost
+)
+
+// DefaultMaxProxyFailures is the number of consecutive failures after which a proxy is ejected.
+const DefaultMaxProxyFailures = 3
+
+// DefaultMaxStickyHosts is the number of hosts remembered by the StickyPerHost strategy.
+const DefaultMaxStickyHosts = 1024
+
+// ProxyStats holds the statistics of a proxy of a ProxyPool.
+type ProxyStats struct {
+	URL                 *url.URL
+	Requests            int64
+	Failures            int64
+	ConsecutiveFailures int
+	LastError           error
+	LastUsed            time.Time
+	Ejected             bool
+}
+
+type proxyEntry struct {
+	ProxyStats
+}
+
+// ProxyPool routes requests through a pool of proxies.
+//
+// HTTP, HTTPS and SOCKS5 proxies are supported: use the http://, https:// and socks5:// schemes.
+// HTTPS targets are tunneled with CONNECT through HTTP and HTTPS proxies.
+// A proxy is ejected from the pool after MaxFailures consecutive failed requests
+// and can be brought back by a health check.
+// With StickyPerHost, at most MaxStickyHosts hosts are remembered: the oldest ones are forgotten first.
+type ProxyPool struct {
+	MaxFailures    int
+	MaxStickyHosts int
+
+	mu          sync.Mutex
+	strategy    ProxyStrategy
+	proxies     []*proxyEntry
+	next        int
+	sticky      map[string]*proxyEntry
+	stickyHosts []string
+	stopCh      chan struct{}
+}
+
+// NewProxyPool returns a new ProxyPool using the provided strategy and proxy urls.
+func NewProxyPool(strategy ProxyStrategy, proxies ...string) (*ProxyPool, error) {
+	p := &ProxyPool{
+		MaxFailures:    DefaultMaxProxyFailures,
+		MaxStickyHosts: DefaultMaxStickyHosts,
+		strategy:       strategy,
+		sticky:         make(map[string]*proxyEntry),
+	}
+	for _, raw := range proxies {
+		if err := p.Add(raw); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Add adds a proxy to the pool.
+func (p *ProxyPool) Add(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return errors.New("Unsupported proxy scheme: " + u.Scheme)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.proxies = append(p.proxies, &proxyEntry{ProxyStats{URL: u}})
+	return nil
+}
+
+// Stats returns the statistics of every proxy of the pool.
+func (p *ProxyPool) Stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ProxyStats, len(p.proxies))
+	for i, e := range p.proxies {
+		stats[i] = e.ProxyStats
+	}
+	return stats
+}
+
+// pick returns the proxy to use for the provided host.
+func (p *ProxyPool) pick(host string) (*proxyEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var healthy []*proxyEntry
+	for _, e := range p.proxies {
+		if !e.Ejected {
+			healthy = append(healthy, e)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, ErrNoProxy
+	}
+	switch p.strategy {
+	case RandomProxy:
+		return healthy[rand.Intn(len(healthy))], nil
+	case StickyPerHost:
+		if e, ok := p.sticky[host]; ok && !e.Ejected {
+			return e, nil
+		}
+		e := healthy[p.next%len(healthy)]
+		p.next++
+		p.stick(host, e)
+		return e, nil
+	default:
+		e := healthy[p.next%len(healthy)]
+		p.next++
+		return e, nil
+	}
+}
+
+// stick remembers the proxy used for host, forgetting the oldest hosts beyond MaxStickyHosts.
+func (p *ProxyPool) stick(host string, e *proxyEntry) {
+	if _, ok := p.sticky[host]; !ok {
+		p.stickyHosts = append(p.stickyHosts, host)
+	}
+	p.sticky[host] = e
+	for p.MaxStickyHosts > 0 && len(p.stickyHosts) > p.MaxStickyHosts {
+		delete(p.sticky, p.stickyHosts[0])
+		p.stickyHosts = p.stickyHosts[1:]
+	}
+}
+
+// report records the outcome of a request made through a proxy.
+func (p *ProxyPool) report(e *proxyEntry, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.Requests++
+	e.LastUsed = time.Now()
+	if err == nil {
+		e.ConsecutiveFailures = 0
+		return
+	}
+	e.Failures++
+	e.ConsecutiveFailures++
+	e.LastError = err
+	if p.MaxFailures > 0 && e.ConsecutiveFailures >= p.MaxFailures {
+		e.Ejected = true
+	}
+}
+
+// setHealth marks a proxy as healthy or ejects it.
+func (p *ProxyPool) setHealth(e *proxyEntry, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.Ejected = err != nil
+	if err != nil {
+		e.LastError = err
+		return
+	}
+	e.ConsecutiveFailures = 0
+}
+
+// HealthCheck requests the target url through every proxy of the pool, ejected ones included.
+// Proxies that fail are ejected and proxies that succeed are put back in the pool.
+func (p *ProxyPool) HealthCheck(target string, timeout time.Duration) {
+	p.mu.Lock()
+	proxies := append([]*proxyEntry(nil), p.proxies...)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range proxies {
+		wg.Add(1)
+		go func(e *proxyEntry) {
+			defer wg.Done()
+			p.setHealth(e, checkProxy(e.URL, target, timeout))
+		}(e)
+	}
+	wg.Wait()
+}
+
+// StartHealthCheck runs HealthCheck at every interval in its own goroutine until StopHealthCheck is called.
+func (p *ProxyPool) StartHealthCheck(target string, interval, timeout time.Duration) {
+	p.mu.Lock()
+	if p.stopCh != nil {
+		p.mu.Unlock()
+		return
+	}
+	stopCh := make(chan struct{})
+	p.stopCh = stopCh
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.HealthCheck(target, timeout)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// StopHealthCheck stops the health checks started by StartHealthCheck.
+func (p *ProxyPool) StopHealthCheck() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopCh != nil {
+		close(p.stopCh)
+		p.stopCh = nil
+	}
+}
+
+func checkProxy(proxyURL *url.URL, target string, timeout time.Duration) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	transport.DisableKeepAlives = true
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+	res, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return errors.New("Proxy health check failed: " + res.Status)
+	}
+	return nil
+}
+
+// Transport returns an http.RoundTripper sending requests through the pool using base.
+// The Proxy field of base is replaced.
+func (p *ProxyPool) Transport(base *http.Transport) http.RoundTripper {
+	t := base.Clone()
+	t.Proxy = proxyFromRequest
+	return &proxyTransport{pool: p, base: t}
+}
+
+type proxyKey struct{}
+
+func proxyFromRequest(req *http.Request) (*url.URL, error) {
+	u, _ := req.Context().Value(proxyKey{}).(*url.URL)
+	return u, nil
+}
+
+type proxyTransport struct {
+	pool *ProxyPool
+	base http.RoundTripper
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e, err := t.pool.pick(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), proxyKey{}, e.URL))
+	res, err := t.base.RoundTrip(req)
+	if err == nil && res.StatusCode == http.StatusProxyAuthRequired {
+		t.pool.report(e, errors.New("Proxy authentication required"))
+		return res, nil
+	}
+	t.pool.report(e, err)
+	return res, err
+}
+
+// WithProxyPool routes the requests through the provided ProxyPool.
+// It takes precedence over WithProxy and only applies when the transport is an *http.Transport.
+func WithProxyPool(pool *ProxyPool) Option {
+	return func(c *config) {
+		c.proxyPool = pool
+	}
+}
@@ -0,0 +1,306 @@
+package spider
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newProxyStandIn returns a server answering every proxied request with its name.
+func newProxyStandIn(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proxy", name)
+	}))
+}
+
+// deadProxyURL returns the url of a proxy refusing connections.
+func deadProxyURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return "http://" + addr
+}
+
+func proxiedBy(t *testing.T, pool *ProxyPool, target string) string {
+	ctx, err := NewHTTPContext("GET", target, nil, WithProxyPool(pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.DoRequest()
+	if err != nil {
+		return ""
+	}
+	res.Body.Close()
+	return res.Header.Get("X-Proxy")
+}
+
+func TestProxyPoolRoundRobin(t *testing.T) {
+	a, b := newProxyStandIn("a"), newProxyStandIn("b")
+	defer a.Close()
+	defer b.Close()
+
+	pool, err := NewProxyPool(RoundRobin, a.URL, b.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "b", "a", "b"}
+	for i, name := range expected {
+		if actual := proxiedBy(t, pool, "http://example.com/"); actual != name {
+			t.Errorf("Request %d: expected proxy %q but got %q", i, name, actual)
+		}
+	}
+	for _, s := range pool.Stats() {
+		if s.Requests != 2 {
+			t.Errorf("Expected 2 requests through %s but got %d", s.URL, s.Requests)
+		}
+	}
+}
+
+func TestProxyPoolStickyPerHost(t *testing.T) {
+	a, b := newProxyStandIn("a"), newProxyStandIn("b")
+	defer a.Close()
+	defer b.Close()
+
+	pool, _ := NewProxyPool(StickyPerHost, a.URL, b.URL)
+	first := proxiedBy(t, pool, "http://one.example.com/")
+	second := proxiedBy(t, pool, "http://two.example.com/")
+	if first == second {
+		t.Errorf("Expected different proxies for different hosts, got %q twice", first)
+	}
+	for i := 0; i < 3; i++ {
+		if actual := proxiedBy(t, pool, "http://one.example.com/"); actual != first {
+			t.Errorf("Expected sticky proxy %q but got %q", first, actual)
+		}
+	}
+}
+
+func TestProxyPoolEjection(t *testing.T) {
+	a := newProxyStandIn("a")
+	defer a.Close()
+	dead := deadProxyURL(t)
+
+	pool, _ := NewProxyPool(RoundRobin, dead, a.URL)
+	pool.MaxFailures = 1
+	proxiedBy(t, pool, "http://example.com/")
+	for i := 0; i < 3; i++ {
+		if actual := proxiedBy(t, pool, "http://example.com/"); actual != "a" {
+			t.Errorf("Expected the dead proxy to be ejected, got %q", actual)
+		}
+	}
+	stats := pool.Stats()
+	if !stats[0].Ejected || stats[0].Failures != 1 {
+		t.Errorf("Expected the dead proxy to be ejected after one failure: %+v", stats[0])
+	}
+
+	pool.HealthCheck("http://example.com/", time.Second)
+	stats = pool.Stats()
+	if !stats[0].Ejected || stats[1].Ejected {
+		t.Errorf("Health check should keep only the live proxy: %+v", stats)
+	}
+}
+
+func TestProxyPoolNoProxy(t *testing.T) {
+	pool, _ := NewProxyPool(RandomProxy)
+	ctx, _ := NewHTTPContext("GET", "http://example.com/", nil, WithProxyPool(pool))
+	if _, err := ctx.DoRequest(); err == nil {
+		t.Error("Expected an error with an empty pool")
+	}
+}
+
+func TestProxyPoolUnsupportedScheme(t *testing.T) {
+	if _, err := NewProxyPool(RoundRobin, "ftp://127.0.0.1:21"); err == nil {
+		t.Error("Expected an error for an unsupported scheme")
+	}
+}
+
+// newConnectProxy returns an HTTP proxy tunneling CONNECT requests and counting them.
+func newConnectProxy(t *testing.T, tunnels *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			t.Error(err)
+			return
+		}
+		atomic.AddInt32(tunnels, 1)
+		pipe(conn, upstream)
+	}))
+}
+
+// newSOCKS5Proxy returns a minimal SOCKS5 proxy without authentication, counting the connections.
+func newSOCKS5Proxy(t *testing.T, tunnels *int32) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				upstream, err := socks5Handshake(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				atomic.AddInt32(tunnels, 1)
+				pipe(conn, upstream)
+			}()
+		}
+	}()
+	return l
+}
+
+func socks5Handshake(conn net.Conn) (net.Conn, error) {
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return nil, err
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return nil, err
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+		host = string(buf[:n])
+	default:
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	return upstream, nil
+}
+
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyConn(a, b)
+	go copyConn(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
+
+func fetchThrough(t *testing.T, proxyURL, target string) {
+	pool, err := NewProxyPool(RoundRobin, proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewHTTPContext("GET", target, nil,
+		WithProxyPool(pool),
+		WithTLSConfig(&tls.Config{InsecureSkipVerify: true}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("X-Target") != "tls" {
+		t.Errorf("Expected the response of the target, got %v", res.Header)
+	}
+}
+
+func newTLSTarget() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Target", "tls")
+	}))
+}
+
+func TestProxyPoolHTTPSConnect(t *testing.T) {
+	target := newTLSTarget()
+	defer target.Close()
+	var tunnels int32
+	proxy := newConnectProxy(t, &tunnels)
+	defer proxy.Close()
+
+	fetchThrough(t, proxy.URL, target.URL)
+	if n := atomic.LoadInt32(&tunnels); n != 1 {
+		t.Errorf("Expected 1 CONNECT tunnel but got %d", n)
+	}
+}
+
+func TestProxyPoolSOCKS5(t *testing.T) {
+	target := newTLSTarget()
+	defer target.Close()
+	var tunnels int32
+	l := newSOCKS5Proxy(t, &tunnels)
+	defer l.Close()
+
+	fetchThrough(t, "socks5://"+l.Addr().String(), target.URL)
+	if n := atomic.LoadInt32(&tunnels); n != 1 {
+		t.Errorf("Expected 1 SOCKS5 connection but got %d", n)
+	}
+}
+
+func TestProxyPoolMaxStickyHosts(t *testing.T) {
+	pool, _ := NewProxyPool(StickyPerHost, "http://127.0.0.1:1", "http://127.0.0.1:2")
+	pool.MaxStickyHosts = 2
+	for _, host := range []string{"a", "b", "c", "a"} {
+		if _, err := pool.pick(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pool.sticky) != 2 || len(pool.stickyHosts) != 2 {
+		t.Errorf("Expected 2 sticky hosts but got %v", pool.stickyHosts)
+	}
+	if _, ok := pool.sticky["b"]; ok {
+		t.Errorf("Expected the oldest host to be forgotten: %v", pool.stickyHosts)
+	}
+}
@@ -0,0 +1,112 @@
+package spider
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrTooManyRedirects = errors.New("Too many redirects")
+)
+
+// DefaultMaxRedirects is the number of redirects followed by SameHostRedirects, like http.Client.
+const DefaultMaxRedirects = 10
+
+// RedirectPolicy decides whether req, the next request of a redirect chain, is made.
+// via holds the requests already made, oldest first. It is used as the CheckRedirect of the http.Client:
+// returning http.ErrUseLastResponse stops at the redirect response, any other error fails the request.
+type RedirectPolicy func(req *http.Request, via []*http.Request) error
+
+// FollowRedirects follows up to DefaultMaxRedirects redirects, the default behavior of http.Client.
+func FollowRedirects(req *http.Request, via []*http.Request) error {
+	return MaxRedirects(DefaultMaxRedirects)(req, via)
+}
+
+// NoRedirects never follows redirects: the redirect response is returned.
+func NoRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// MaxRedirects follows up to max redirects and fails with ErrTooManyRedirects after.
+func MaxRedirects(max int) RedirectPolicy {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return ErrTooManyRedirects
+		}
+		return nil
+	}
+}
+
+// SameHostRedirects follows up to DefaultMaxRedirects redirects as long as they stay on the host of the first request.
+// A redirect to another host is returned as the response.
+func SameHostRedirects(req *http.Request, via []*http.Request) error {
+	if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return http.ErrUseLastResponse
+	}
+	return FollowRedirects(req, via)
+}
+
+// WithRedirectPolicy sets the RedirectPolicy of the client.
+func WithRedirectPolicy(policy RedirectPolicy) Option {
+	return func(c *config) {
+		c.redirectPolicy = policy
+	}
+}
+
+// SetRedirectPolicy sets the RedirectPolicy of the client of this context.
+// The client is copied so that the contexts sharing it are not affected.
+func (c *Context) SetRedirectPolicy(policy RedirectPolicy) {
+	client := http.Client{}
+	if c.Client != nil {
+		client = *c.Client
+	}
+	client.CheckRedirect = policy
+	c.Client = &client
+}
+
+// Redirect is a redirect response of a redirect chain.
+type Redirect struct {
+	// URL is the URL of the request that got the redirect.
+	URL        string
+	StatusCode int
+	Location   string
+}
+
+// Redirects returns the redirects of the last request made by DoRequest, oldest first.
+// The last one is the response itself if it is a redirect that has not been followed.
+func (c *Context) Redirects() []Redirect {
+	return append([]Redirect(nil), c.redirects...)
+}
+
+// redirectChain returns the redirects that led to res.
+func redirectChain(res *http.Response) []Redirect {
+	var chain []Redirect
+	if isRedirect(res) {
+		chain = append(chain, newRedirect(res))
+	}
+	for r := res.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		chain = append(chain, newRedirect(r.Response))
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+func newRedirect(res *http.Response) Redirect {
+	r := Redirect{StatusCode: res.StatusCode, Location: res.Header.Get("Location")}
+	if res.Request != nil {
+		r.URL = res.Request.URL.String()
+	}
+	return r
+}
+
+func isRedirect(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return res.Header.Get("Location") != ""
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package spider
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectPolicies(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/elsewhere", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	get := func(path string, opts ...Option) (*Context, error) {
+		ctx, err := NewHTTPContext("GET", ts.URL+path, nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ctx.DoRequest()
+		return ctx, err
+	}
+
+	ctx, err := get("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirects := ctx.Redirects()
+	if len(redirects) != 2 || redirects[0].URL != ts.URL+"/a" || redirects[0].StatusCode != http.StatusMovedPermanently ||
+		redirects[1].URL != ts.URL+"/b" || redirects[1].Location != "/login" {
+		t.Errorf("Unexpected redirect chain %+v", redirects)
+	}
+	if ctx.Response().Request.URL.Path != "/login" {
+		t.Errorf("Expected the redirects to be followed, got %s", ctx.Response().Request.URL)
+	}
+
+	ctx, err = get("/a", WithRedirectPolicy(NoRedirects))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Response().StatusCode != http.StatusMovedPermanently || len(ctx.Redirects()) != 1 {
+		t.Errorf("Expected the redirect response, got %d with %+v", ctx.Response().StatusCode, ctx.Redirects())
+	}
+
+	if _, err = get("/a", WithRedirectPolicy(MaxRedirects(1))); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Expected ErrTooManyRedirects but got %v", err)
+	}
+
+	ctx, err = get("/away", WithRedirectPolicy(SameHostRedirects))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Response().StatusCode != http.StatusFound || ctx.Redirects()[0].Location != other.URL+"/elsewhere" {
+		t.Errorf("Expected the redirect to another host not to be followed, got %d", ctx.Response().StatusCode)
+	}
+
+	parent, _ := get("/a")
+	child := parent.Extend(mustRequest(t, ts.URL+"/a"))
+	child.SetRedirectPolicy(NoRedirects)
+	if parent.Client.CheckRedirect != nil {
+		t.Error("Expected SetRedirectPolicy not to change the client of the parent")
+	}
+}
+
+func mustRequest(t *testing.T, url string) *http.Request {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
@@ -0,0 +1,151 @@
+package spider
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRenderMethod = errors.New("Only GET requests can be rendered")
+)
+
+// DefaultRenderTimeout is the time a Renderer waits for a page when Wait has no Timeout.
+const DefaultRenderTimeout = 30 * time.Second
+
+// Wait tells a Renderer when a page is ready to be read.
+// The page is always waited for until it is loaded.
+type Wait struct {
+	// Selector waits for an element matching this CSS selector to be in the page.
+	Selector string
+	// NetworkIdle waits for the page to stop making requests.
+	NetworkIdle bool
+	// Timeout is the maximum time spent loading and waiting for the page, DefaultRenderTimeout if zero.
+	Timeout time.Duration
+}
+
+func (w Wait) timeout() time.Duration {
+	if w.Timeout > 0 {
+		return w.Timeout
+	}
+	return DefaultRenderTimeout
+}
+
+// RenderedPage is a page loaded by a Renderer.
+type RenderedPage struct {
+	// HTML is the serialized DOM of the page once loaded.
+	HTML string
+	// StatusCode is the status of the response of the main document, 200 if zero.
+	StatusCode int
+	// URL is the final URL of the main document, after redirects. It is the URL of the request if empty.
+	URL string
+	// Header holds the headers of the response of the main document.
+	Header http.Header
+}
+
+// response returns the http.Response of the page for req.
+func (p *RenderedPage) response(req *http.Request) (*http.Response, error) {
+	code := p.StatusCode
+	if code == 0 {
+		code = http.StatusOK
+	}
+	header := make(http.Header, len(p.Header)+1)
+	for k, v := range p.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	// The body is the serialized DOM, not the bytes sent by the server.
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	if p.URL != "" && p.URL != req.URL.String() {
+		u, err := req.URL.Parse(p.URL)
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.URL = u
+		req.Host = u.Host
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(p.HTML)),
+		ContentLength: int64(len(p.HTML)),
+		Request:       req,
+	}, nil
+}
+
+// Renderer loads a page in a browser, running its scripts, and returns the resulting HTML
+// along with the status and the final URL of the main document.
+//
+// The request carries the headers and cookies to send and its context cancels the rendering.
+// Render must be safe for concurrent use.
+type Renderer interface {
+	Render(req *http.Request, wait Wait) (*RenderedPage, error)
+}
+
+// RendererFunc is an adapter to use a function as a Renderer.
+type RendererFunc func(req *http.Request, wait Wait) (*RenderedPage, error)
+
+func (fn RendererFunc) Render(req *http.Request, wait Wait) (*RenderedPage, error) {
+	return fn(req, wait)
+}
+
+// WithRenderer makes DoRequest load the page with the provided Renderer instead of a plain HTTP request.
+// The response then holds the rendered HTML, which can be parsed with HTMLParser,
+// with the status and the final URL of the page.
+//
+// The page is loaded by the browser, not by the http.Client of the context: the transport
+// options, like WithProxyPool, WithHeaderRotation, WithCache and WithCircuitBreaker, do not apply to it.
+//
+// Passed to NewScheduler, the renderer is shared by every spider and closed by Stop if it implements io.Closer.
+func WithRenderer(r Renderer, wait Wait) Option {
+	return func(c *config) {
+		c.renderer = r
+		c.renderWait = wait
+	}
+}
+
+// render loads the request of the context with its Renderer and sets a response holding the rendered HTML.
+func (c *Context) render() (*http.Response, error) {
+	req := c.Request()
+	if req.Method != "" && req.Method != "GET" {
+		return nil, ErrRenderMethod
+	}
+	r := req.Clone(req.Context())
+	r.Header.Del("Content-Type")
+	r.Header.Del("Content-Length")
+	if c.Client != nil && c.Client.Jar != nil {
+		for _, cookie := range c.Client.Jar.Cookies(req.URL) {
+			r.AddCookie(cookie)
+		}
+	}
+
+	page, err := c.renderer.Render(r, c.renderWait)
+	if err != nil {
+		return nil, err
+	}
+	res, err := page.response(req)
+	if err != nil {
+		return nil, err
+	}
+	c.SetResponse(res)
+	if c.changeDetector != nil {
+		err = c.bufferBody(res)
+	}
+	return res, err
+}
+
+func (in *InMemory) closeRenderer() {
+	if c, ok := newConfig(in.options).renderer.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,232 @@
+package spider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const renderFixture = `<html><body><div id="app"></div>
+<script>setTimeout(function() { document.getElementById("app").innerHTML = '<p class="item">rendered</p>'; }, 50);</script>
+</body></html>`
+
+func TestRendererFeedsHTMLParser(t *testing.T) {
+	var got *http.Request
+	r := RendererFunc(func(req *http.Request, wait Wait) (*RenderedPage, error) {
+		got = req
+		return &RenderedPage{
+			HTML:       `<div id="app"><p class="item">rendered</p></div>`,
+			StatusCode: http.StatusNotFound,
+			URL:        "http://example.com/app/",
+		}, nil
+	})
+	ctx, err := NewHTTPContext("GET", "http://example.com/spa", nil, WithRenderer(r, Wait{Selector: ".item"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Client.Jar.SetCookies(ctx.Request().URL, []*http.Cookie{{Name: "session", Value: "abc"}})
+	res, err := ctx.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status of the page but got %d", res.StatusCode)
+	}
+	if u, err := ctx.AbsURL("next"); err != nil || u.String() != "http://example.com/app/next" {
+		t.Errorf("Expected links to be resolved against the final URL, got %v", u)
+	}
+	doc, err := ctx.HTMLParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := doc.Find(".item").Text(); text != "rendered" {
+		t.Errorf("Expected the rendered DOM to be parsed, got %q", text)
+	}
+	if c, err := got.Cookie("session"); err != nil || c.Value != "abc" {
+		t.Error("Expected the cookies of the jar to be passed to the renderer")
+	}
+
+	post, _ := NewHTTPContext("POST", "http://example.com/spa", nil, WithRenderer(r, Wait{}))
+	if _, err := post.DoRequest(); err != ErrRenderMethod {
+		t.Errorf("Expected ErrRenderMethod but got %v", err)
+	}
+}
+
+// fakeBrowser answers the DevTools commands used by CDPRenderer.
+type fakeBrowser struct {
+	mu        sync.Mutex
+	navigated []string
+	headers   map[string]interface{}
+	cookies   []interface{}
+	contexts  []string
+}
+
+func (b *fakeBrowser) serve(ws *websocket.Conn) {
+	var writeMu sync.Mutex
+	send := func(v interface{}) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		websocket.JSON.Send(ws, v)
+	}
+	var url string
+	for {
+		var msg struct {
+			ID        int64                  `json:"id"`
+			SessionID string                 `json:"sessionId"`
+			Method    string                 `json:"method"`
+			Params    map[string]interface{} `json:"params"`
+		}
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+		result := map[string]interface{}{}
+		switch msg.Method {
+		case "Target.createBrowserContext":
+			result["browserContextId"] = "context"
+		case "Target.createTarget", "Target.closeTarget", "Target.disposeBrowserContext":
+			b.mu.Lock()
+			b.contexts = append(b.contexts, fmt.Sprintf("%s %v", msg.Method, msg.Params["browserContextId"]))
+			b.mu.Unlock()
+			result["targetId"] = "target"
+		case "Target.attachToTarget":
+			result["sessionId"] = "session"
+		case "Network.setCookies":
+			b.mu.Lock()
+			b.cookies = msg.Params["cookies"].([]interface{})
+			b.mu.Unlock()
+		case "Network.setExtraHTTPHeaders":
+			b.mu.Lock()
+			b.headers = msg.Params["headers"].(map[string]interface{})
+			b.mu.Unlock()
+		case "Page.navigate":
+			url = msg.Params["url"].(string)
+			b.mu.Lock()
+			b.navigated = append(b.navigated, url)
+			b.mu.Unlock()
+			for _, loader := range []string{"frame", "loader"} {
+				send(map[string]interface{}{
+					"sessionId": msg.SessionID,
+					"method":    "Network.responseReceived",
+					"params": map[string]interface{}{
+						"loaderId": loader,
+						"type":     "Document",
+						"response": map[string]interface{}{
+							"url":     url + "/" + loader,
+							"status":  http.StatusGone,
+							"headers": map[string]string{"x-page": loader},
+						},
+					},
+				})
+			}
+			for _, name := range []string{"init", "load", "networkIdle"} {
+				send(map[string]interface{}{
+					"sessionId": msg.SessionID,
+					"method":    "Page.lifecycleEvent",
+					"params":    map[string]interface{}{"loaderId": "loader", "name": name},
+				})
+			}
+			result["loaderId"] = "loader"
+		case "Runtime.evaluate":
+			var value interface{} = true
+			if strings.Contains(msg.Params["expression"].(string), "outerHTML") {
+				value = fmt.Sprintf(`<html><body><div id="app"><p class="item">%s</p></div></body></html>`, url)
+			}
+			result["result"] = map[string]interface{}{"value": value}
+		}
+		send(map[string]interface{}{"id": msg.ID, "sessionId": msg.SessionID, "result": result})
+	}
+}
+
+func TestCDPRenderer(t *testing.T) {
+	browser := &fakeBrowser{}
+	ts := httptest.NewServer(websocket.Handler(browser.serve))
+	defer ts.Close()
+
+	r := &CDPRenderer{URL: "ws" + strings.TrimPrefix(ts.URL, "http"), MaxTabs: 2}
+	defer r.Close()
+	ctx, err := NewHTTPContext("GET", "http://example.com/spa", nil,
+		WithRenderer(r, Wait{Selector: ".item", NetworkIdle: true, Timeout: 5 * time.Second}),
+		WithHeaders(http.Header{"X-Token": {"secret"}, "Authorization": {"Bearer secret"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Client.Jar.SetCookies(ctx.Request().URL, []*http.Cookie{{Name: "session", Value: "abc"}})
+	res, err := ctx.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusGone || res.Request.URL.String() != "http://example.com/spa/loader" {
+		t.Errorf("Expected the status and the URL of the main document, got %d for %s", res.StatusCode, res.Request.URL)
+	}
+	if res.Header.Get("X-Page") != "loader" {
+		t.Errorf("Expected the headers of the main document, got %v", res.Header)
+	}
+	doc, err := ctx.HTMLParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := doc.Find(".item").Text(); text != "http://example.com/spa" {
+		t.Errorf("Unexpected rendered content %q", text)
+	}
+	browser.mu.Lock()
+	defer browser.mu.Unlock()
+	if len(browser.navigated) != 1 || browser.headers["X-Token"] != "secret" {
+		t.Errorf("Unexpected navigation %v with headers %v", browser.navigated, browser.headers)
+	}
+	for _, k := range []string{"Content-Type", "Cookie", "Authorization"} {
+		if _, ok := browser.headers[k]; ok {
+			t.Errorf("Expected %s not to be sent with every request of the tab", k)
+		}
+	}
+	if len(browser.cookies) != 1 {
+		t.Fatalf("Expected the cookie to be set in the browser, got %v", browser.cookies)
+	}
+	cookie := browser.cookies[0].(map[string]interface{})
+	if cookie["name"] != "session" || cookie["url"] != "http://example.com/spa" {
+		t.Errorf("Expected the cookie to be scoped to the page URL, got %v", cookie)
+	}
+	expected := "Target.createTarget context,Target.closeTarget <nil>,Target.disposeBrowserContext context"
+	if contexts := strings.Join(browser.contexts, ","); contexts != expected {
+		t.Errorf("Expected the tab to have its own browser context, got %q", contexts)
+	}
+}
+
+func TestCDPRendererWithChrome(t *testing.T) {
+	var found bool
+	for _, name := range browserNames {
+		if _, err := exec.LookPath(name); err == nil {
+			found = true
+		}
+	}
+	if !found {
+		t.Skip("No Chrome or Chromium executable found")
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, renderFixture)
+	}))
+	defer ts.Close()
+
+	r := &CDPRenderer{Flags: []string{"--no-sandbox"}}
+	defer r.Close()
+	ctx, err := NewHTTPContext("GET", ts.URL, nil, WithRenderer(r, Wait{Selector: ".item"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.DoRequest(); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ctx.HTMLParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := doc.Find(".item").Text(); text != "rendered" {
+		t.Errorf("Expected the page scripts to run, got %q", text)
+	}
+}
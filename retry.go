@@ -0,0 +1,218 @@
+package spider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// RetryCondition reports whether a request should be retried given its response or its error.
+type RetryCondition func(res *http.Response, err error) bool
+
+// Any returns a RetryCondition matching if any of the provided conditions matches.
+func Any(conds ...RetryCondition) RetryCondition {
+	return func(res *http.Response, err error) bool {
+		for _, cond := range conds {
+			if cond(res, err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All returns a RetryCondition matching if all the provided conditions match.
+func All(conds ...RetryCondition) RetryCondition {
+	return func(res *http.Response, err error) bool {
+		for _, cond := range conds {
+			if !cond(res, err) {
+				return false
+			}
+		}
+		return len(conds) > 0
+	}
+}
+
+// RetryOnStatus returns a RetryCondition matching responses with one of the provided status codes.
+func RetryOnStatus(codes ...int) RetryCondition {
+	return func(res *http.Response, err error) bool {
+		if err != nil || res == nil {
+			return false
+		}
+		for _, code := range codes {
+			if res.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RetryOnStatusRange returns a RetryCondition matching responses whose status code is between min and max included.
+func RetryOnStatusRange(min, max int) RetryCondition {
+	return func(res *http.Response, err error) bool {
+		return err == nil && res != nil && res.StatusCode >= min && res.StatusCode <= max
+	}
+}
+
+// RetryOnServerError matches responses with a 5xx status code.
+func RetryOnServerError(res *http.Response, err error) bool {
+	return RetryOnStatusRange(500, 599)(res, err)
+}
+
+// RetryOnTooManyRequests matches responses with a 429 status code.
+func RetryOnTooManyRequests(res *http.Response, err error) bool {
+	return RetryOnStatus(http.StatusTooManyRequests)(res, err)
+}
+
+// RetryOnTimeout matches errors caused by a timeout.
+func RetryOnTimeout(res *http.Response, err error) bool {
+	var netErr net.Error
+	return err != nil && !errors.Is(err, context.Canceled) && errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryOnConnectionError matches errors caused by a connection reset, refused or closed unexpectedly.
+func RetryOnConnectionError(res *http.Response, err error) bool {
+	return err != nil && (errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+// RetryPolicy retries the requests of a Context.
+//
+// It can be attached to a Context with WithRetryPolicy, or to every spider of a scheduler
+// by passing WithRetryPolicy to NewScheduler.
+type RetryPolicy struct {
+	// Condition classifies the responses and errors that should be retried.
+	Condition RetryCondition
+	// MaxAttempts is the maximum number of attempts, the first one included. Zero means no limit.
+	MaxAttempts int
+	// MaxElapsedTime caps the total time spent retrying. Zero means no limit.
+	MaxElapsedTime time.Duration
+	// NewBackOff returns the BackOff used between attempts.
+	// It is called for each request since a BackOff holds its own state.
+	NewBackOff func() backoff.BackOff
+	// RespectRetryAfter waits for the duration of the Retry-After header of the response, when it is set.
+	RespectRetryAfter bool
+}
+
+// DefaultRetryPolicy returns a RetryPolicy retrying timeouts, connection errors, 429 and 5xx responses
+// up to 3 times in 1 minute with an exponential BackOff, honoring Retry-After.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Condition:         Any(RetryOnTimeout, RetryOnConnectionError, RetryOnTooManyRequests, RetryOnServerError),
+		MaxAttempts:       4,
+		MaxElapsedTime:    time.Minute,
+		NewBackOff:        func() backoff.BackOff { return backoff.NewExponentialBackOff() },
+		RespectRetryAfter: true,
+	}
+}
+
+// do calls fn until its result does not match the Condition of the policy or the limits are reached.
+// It returns the result of the last attempt.
+func (p *RetryPolicy) do(req *http.Request, fn func() (*http.Response, error)) (*http.Response, error) {
+	if err := makeRewindable(req); err != nil {
+		return nil, err
+	}
+	var b backoff.BackOff = &backoff.ZeroBackOff{}
+	if p.NewBackOff != nil {
+		b = p.NewBackOff()
+	}
+	b.Reset()
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		res, err := fn()
+		if p.Condition == nil || !p.Condition(res, err) {
+			return res, err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return res, err
+		}
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return res, err
+		}
+		if after, ok := retryAfter(res); ok && p.RespectRetryAfter {
+			wait = after
+		}
+		if p.MaxElapsedTime > 0 && time.Since(start)+wait > p.MaxElapsedTime {
+			return res, err
+		}
+		if rerr := rewindBody(req); rerr != nil {
+			return res, err
+		}
+		if res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryAfter returns the duration to wait according to the Retry-After header of the response.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// makeRewindable buffers the body of a request that cannot be rewound
+// so that it can be sent again.
+func makeRewindable(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+// WithRetryPolicy retries the requests made by DoRequest according to the provided policy.
+func WithRetryPolicy(policy *RetryPolicy) Option {
+	return func(c *config) {
+		c.retryPolicy = policy
+	}
+}
+
+// SetRetryPolicy sets the policy used to retry the requests made by DoRequest.
+// A nil policy disables the retries.
+func (c *Context) SetRetryPolicy(policy *RetryPolicy) {
+	c.retryPolicy = policy
+}
@@ -0,0 +1,122 @@
+package spider
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+func fastRetryPolicy(cond RetryCondition) *RetryPolicy {
+	return &RetryPolicy{
+		Condition:         cond,
+		MaxAttempts:       5,
+		NewBackOff:        func() backoff.BackOff { return backoff.NewConstantBackOff(time.Millisecond) },
+		RespectRetryAfter: true,
+	}
+}
+
+func TestRetryPolicyRewindsBody(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		switch len(bodies) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	// A reader without GetBody support
+	body := ioutil.NopCloser(strings.NewReader("payload"))
+	ctx, err := NewHTTPContext("POST", ts.URL, body, WithRetryPolicy(fastRetryPolicy(Any(RetryOnTooManyRequests, RetryOnServerError))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected the last attempt to succeed, got %s", res.Status)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("Expected 3 attempts but got %d", len(bodies))
+	}
+	for i, b := range bodies {
+		if b != "payload" {
+			t.Errorf("Attempt %d: expected body %q but got %q", i+1, "payload", b)
+		}
+	}
+}
+
+func TestRetryPolicyLimits(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	policy := fastRetryPolicy(RetryOnServerError)
+	policy.MaxElapsedTime = time.Second
+	ctx, _ := NewHTTPContext("GET", ts.URL, nil, WithRetryPolicy(policy))
+	start := time.Now()
+	res, err := ctx.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusServiceUnavailable || attempts != 1 {
+		t.Errorf("A Retry-After beyond MaxElapsedTime should stop retrying, got %d attempts", attempts)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Should not wait for the Retry-After")
+	}
+
+	attempts = 0
+	policy.RespectRetryAfter = false
+	policy.MaxAttempts = 3
+	ctx.SetRetryPolicy(policy)
+	if _, err := ctx.DoRequest(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts but got %d", attempts)
+	}
+}
+
+func TestRetryConditions(t *testing.T) {
+	res := func(code int) *http.Response { return &http.Response{StatusCode: code} }
+	testCases := []struct {
+		name     string
+		cond     RetryCondition
+		res      *http.Response
+		err      error
+		expected bool
+	}{
+		{"status", RetryOnStatus(404), res(404), nil, true},
+		{"status mismatch", RetryOnStatus(404), res(200), nil, false},
+		{"range", RetryOnStatusRange(500, 503), res(503), nil, true},
+		{"range outside", RetryOnStatusRange(500, 503), res(504), nil, false},
+		{"server error", RetryOnServerError, res(500), nil, true},
+		{"connection error", RetryOnConnectionError, nil, errors.New("boom"), false},
+		{"any", Any(RetryOnStatus(404), RetryOnServerError), res(502), nil, true},
+		{"all", All(RetryOnServerError, RetryOnStatus(502)), res(503), nil, false},
+		{"all match", All(RetryOnServerError, RetryOnStatus(503)), res(503), nil, true},
+	}
+	for _, tc := range testCases {
+		if actual := tc.cond(tc.res, tc.err); actual != tc.expected {
+			t.Errorf("%s: expected %v but got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
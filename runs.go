@@ -0,0 +1,73 @@
+package spider
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// MaxRunHistory is the number of runs kept in the history of an entry.
+const MaxRunHistory = 100
+
+// Run is a run of an entry, retries included.
+type Run struct {
+	// Attempt is 1 for a scheduled run and greater than 1 for its retries.
+	Attempt int
+	Start   time.Time
+	End     time.Time
+	Err     error
+}
+
+// Retry reports whether the run is a retry of a failed run.
+func (r Run) Retry() bool {
+	return r.Attempt > 1
+}
+
+// RunRetry configures how the failed runs of an entry are retried.
+//
+// A run fails when Setup or Spin returns an error. Retries happen between two scheduled runs
+// and do not change the Next time of the entry.
+type RunRetry struct {
+	// MaxAttempts is the maximum number of attempts of a run, the first one included.
+	MaxAttempts int
+	// NewBackOff returns the BackOff used between attempts.
+	// An exponential BackOff is used if it is nil.
+	NewBackOff func() backoff.BackOff
+	// Jitter randomly increases or decreases each wait by up to this fraction of it, between 0 and 1.
+	Jitter float64
+}
+
+func (r *RunRetry) newBackOff() backoff.BackOff {
+	if r.NewBackOff != nil {
+		return r.NewBackOff()
+	}
+	return backoff.NewExponentialBackOff()
+}
+
+func (r *RunRetry) jitter(wait time.Duration) time.Duration {
+	if r.Jitter <= 0 {
+		return wait
+	}
+	jitter := r.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	return time.Duration(float64(wait) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// History returns the last runs of the entry, oldest first.
+func (e *Entry) History() []Run {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Run(nil), e.history...)
+}
+
+func (e *Entry) record(run Run) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.history = append(e.history, run)
+	if len(e.history) > MaxRunHistory {
+		e.history = e.history[len(e.history)-MaxRunHistory:]
+	}
+}
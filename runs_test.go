@@ -0,0 +1,84 @@
+package spider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+type flakySpider struct {
+	failures int
+	runs     int
+}
+
+func (f *flakySpider) Setup(parent *Context) (*Context, error) { return NewContext(), nil }
+func (f *flakySpider) Spin(ctx *Context) error {
+	f.runs++
+	if f.runs <= f.failures {
+		return errors.New("flaky")
+	}
+	return nil
+}
+
+func TestRunRetry(t *testing.T) {
+	s := &flakySpider{failures: 2}
+	e := &Entry{
+		Spider: s,
+		Retry: &RunRetry{
+			MaxAttempts: 5,
+			NewBackOff:  func() backoff.BackOff { return backoff.NewConstantBackOff(time.Millisecond) },
+			Jitter:      0.5,
+		},
+	}
+	NewScheduler().runEntry(e, nil)
+
+	history := e.History()
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 runs but got %d", len(history))
+	}
+	for i, run := range history {
+		if run.Attempt != i+1 {
+			t.Errorf("Run %d: expected attempt %d but got %d", i, i+1, run.Attempt)
+		}
+		if failed := run.Err != nil; failed != (i < 2) {
+			t.Errorf("Run %d: unexpected error %v", i, run.Err)
+		}
+	}
+	if history[0].Retry() || !history[2].Retry() {
+		t.Error("Only the runs after the first one should be retries")
+	}
+}
+
+func TestRunRetryMaxAttempts(t *testing.T) {
+	s := &flakySpider{failures: 10}
+	e := &Entry{
+		Spider: s,
+		Retry: &RunRetry{
+			MaxAttempts: 2,
+			NewBackOff:  func() backoff.BackOff { return &backoff.ZeroBackOff{} },
+		},
+	}
+	NewScheduler().runEntry(e, nil)
+	if s.runs != 2 {
+		t.Errorf("Expected 2 runs but got %d", s.runs)
+	}
+}
+
+func TestRunRetryAbandonedWhenStopped(t *testing.T) {
+	s := &flakySpider{failures: 10}
+	e := &Entry{
+		Spider: s,
+		Retry: &RunRetry{
+			MaxAttempts: 5,
+			NewBackOff:  func() backoff.BackOff { return backoff.NewConstantBackOff(time.Hour) },
+		},
+	}
+	done := make(chan struct{})
+	close(done)
+	NewScheduler().runEntry(e, done)
+	if s.runs != 1 {
+		t.Errorf("Expected a single run but got %d", s.runs)
+	}
+}
@@ -0,0 +1,299 @@
+package spider
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	ErrLoggedOut     = errors.New("Still logged out after logging in")
+	ErrNotReplayable = errors.New("Request body cannot be replayed")
+	ErrNoLoginSpider = errors.New("No login spider has been set")
+)
+
+// LoggedOutFunc reports whether a response means that the session is not logged in anymore.
+type LoggedOutFunc func(*http.Response) bool
+
+// Session keeps a spider logged in.
+//
+// It runs a login spider, keeps the resulting cookies in its Jar and the resulting tokens
+// in its headers and values. When a response of DoRequest is detected as logged out,
+// the login spider is run again and the request is replayed.
+//
+// A Session is safe for concurrent use and can be shared by several contexts.
+type Session struct {
+	// Jar holds the cookies of the session.
+	// It can be replaced by a persistent or shared jar before the session is used.
+	Jar http.CookieJar
+	// MaxRelogins is the number of times a request is replayed after logging in again.
+	MaxRelogins int
+	// Hosts are the hosts receiving the headers of the session, with or without port.
+	// If empty, the headers are only sent to the host of the request of the login spider.
+	Hosts []string
+
+	login     Spider
+	loggedOut LoggedOutFunc
+
+	loginMu    sync.Mutex
+	mu         sync.Mutex
+	generation int
+	header     http.Header
+	loginHost  string
+	store      Store
+}
+
+// NewSession returns a new Session using the provided login spider and logged out predicate.
+//
+// The login spider gets the Context of the request that triggered the login as parent.
+// Its Spin can save tokens with ctx.Session().SetHeader or ctx.Session().Set.
+func NewSession(login Spider, loggedOut LoggedOutFunc) *Session {
+	jar, _ := NewCookieJarFrom(nil)
+	return &Session{
+		Jar:         jar,
+		MaxRelogins: 1,
+		login:       login,
+		loggedOut:   loggedOut,
+		header:      make(http.Header),
+		store:       NewKVStore(),
+	}
+}
+
+// SetHeader sets a header sent with every request of the session to its Hosts, an Authorization token for example.
+func (s *Session) SetHeader(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header.Set(key, value)
+}
+
+// Header returns a copy of the headers sent with every request of the session.
+func (s *Session) Header() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return cloneHeader(s.header)
+}
+
+// Set a value to this session
+func (s *Session) Set(key string, value interface{}) {
+	s.store.Set(key, value)
+}
+
+// Get a value from this session
+func (s *Session) Get(key string) interface{} {
+	v, _ := s.store.Get(key)
+	return v
+}
+
+// Login runs the login spider.
+func (s *Session) Login(parent *Context) error {
+	s.mu.Lock()
+	gen := s.generation
+	s.mu.Unlock()
+	return s.relogin(parent, gen)
+}
+
+// relogin runs the login spider unless another login happened since generation gen.
+func (s *Session) relogin(parent *Context, gen int) error {
+	if s.login == nil {
+		return ErrNoLoginSpider
+	}
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	s.mu.Lock()
+	current := s.generation
+	s.mu.Unlock()
+	if current != gen {
+		return nil
+	}
+	ctx, err := s.login.Setup(parent)
+	if err != nil {
+		return err
+	}
+	if ctx.Client == nil {
+		if _, err := ctx.NewClient(); err != nil {
+			return err
+		}
+	}
+	ctx.Client.Jar = s.Jar
+	ctx.session = s
+	ctx.loggingIn = true
+	if req := ctx.Request(); req != nil && req.URL != nil {
+		s.mu.Lock()
+		s.loginHost = req.URL.Host
+		s.mu.Unlock()
+	}
+	if err := s.login.Spin(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.generation++
+	s.mu.Unlock()
+	return nil
+}
+
+// do makes the request of the context, logging in again and replaying it if the response is logged out.
+func (s *Session) do(c *Context) (*http.Response, error) {
+	for i := 0; ; i++ {
+		s.mu.Lock()
+		gen := s.generation
+		if s.sendsHeaderTo(c.request.URL) {
+			for k, v := range s.header {
+				c.request.Header[k] = append([]string(nil), v...)
+			}
+		}
+		s.mu.Unlock()
+
+		res, err := c.do()
+		if err != nil || c.loggingIn || s.loggedOut == nil || !s.loggedOut(streamHead(c, res)) {
+			return res, err
+		}
+		if i >= s.MaxRelogins {
+			return res, ErrLoggedOut
+		}
+		if err := rewindBody(c.request); err != nil {
+			return res, err
+		}
+		res.Body.Close()
+		if err := s.relogin(c, gen); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// streamHead returns the response of a stream without its body, which never ends,
+// so that a LoggedOutFunc reading it only sees its status and headers.
+func streamHead(c *Context, res *http.Response) *http.Response {
+	if !c.stream {
+		return res
+	}
+	head := *res
+	head.Body = http.NoBody
+	return &head
+}
+
+// sendsHeaderTo reports whether the headers of the session are sent to u.
+// s.mu must be held.
+func (s *Session) sendsHeaderTo(u *url.URL) bool {
+	if u == nil {
+		return false
+	}
+	if len(s.Hosts) == 0 {
+		return s.loginHost != "" && strings.EqualFold(s.loginHost, u.Host)
+	}
+	for _, host := range s.Hosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewindBody replaces the body of a request that has already been sent by a fresh copy.
+func rewindBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.GetBody == nil {
+		return ErrNotReplayable
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// LoggedOutIfStatus returns a LoggedOutFunc detecting responses with one of the provided status codes.
+func LoggedOutIfStatus(codes ...int) LoggedOutFunc {
+	return func(res *http.Response) bool {
+		for _, code := range codes {
+			if res.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// LoggedOutIfRedirectedTo returns a LoggedOutFunc detecting responses that have been redirected
+// to an url whose path starts with the provided one, a login page for example.
+func LoggedOutIfRedirectedTo(path string) LoggedOutFunc {
+	return func(res *http.Response) bool {
+		if res.Request == nil || res.Request.URL == nil {
+			return false
+		}
+		if loc := res.Header.Get("Location"); loc != "" && res.StatusCode >= 300 && res.StatusCode < 400 {
+			if u, err := res.Request.URL.Parse(loc); err == nil && strings.HasPrefix(u.Path, path) {
+				return true
+			}
+		}
+		return strings.HasPrefix(res.Request.URL.Path, path)
+	}
+}
+
+// LoggedOutIfSelector returns a LoggedOutFunc detecting HTML responses containing an element
+// matching the provided selector, a login form for example.
+// The body of the response is read and replaced so that it can still be parsed.
+func LoggedOutIfSelector(selector string) LoggedOutFunc {
+	return func(res *http.Response) bool {
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return false
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			return false
+		}
+		return doc.Find(selector).Length() > 0
+	}
+}
+
+// LoggedOutIfAny returns a LoggedOutFunc detecting responses matched by any of the provided ones.
+func LoggedOutIfAny(fns ...LoggedOutFunc) LoggedOutFunc {
+	return func(res *http.Response) bool {
+		for _, fn := range fns {
+			if fn(res) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WithSession attaches a Session to the context.
+// The client uses the Jar of the session unless a cookie jar is set with WithCookieJar.
+func WithSession(session *Session) Option {
+	return func(c *config) {
+		c.session = session
+	}
+}
+
+// Session returns the Session attached to this context, if any.
+func (c *Context) Session() *Session {
+	return c.session
+}
+
+// SetSession attaches a Session to this context and uses its cookie jar.
+func (c *Context) SetSession(session *Session) {
+	c.session = session
+	if c.Client != nil && session != nil {
+		c.Client.Jar = session.Jar
+	}
+}
@@ -0,0 +1,164 @@
+package spider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newLoginServer returns a server requiring a session cookie set by POST /login.
+// Sessions are invalidated when /expire is requested.
+func newLoginServer(logins *int32) *httptest.Server {
+	var valid atomic.Value
+	valid.Store("")
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			n := atomic.AddInt32(logins, 1)
+			token := "token" + string(rune('0'+n))
+			valid.Store(token)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: token, Path: "/"})
+			w.Header().Set("X-Token", token)
+		case "/login-page":
+			w.Write([]byte("please log in"))
+		case "/expire":
+			valid.Store("")
+		default:
+			c, err := r.Cookie("session")
+			if err != nil || c.Value != valid.Load().(string) {
+				http.Redirect(w, r, "/login-page", http.StatusFound)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(append([]byte("private:"), body...))
+		}
+	}))
+}
+
+func TestSessionReloginAndReplay(t *testing.T) {
+	var logins int32
+	ts := newLoginServer(&logins)
+	defer ts.Close()
+
+	login := Post(ts.URL+"/login", nil, func(ctx *Context) error {
+		res, err := ctx.DoRequest()
+		if err != nil {
+			return err
+		}
+		ctx.Session().Set("token", res.Header.Get("X-Token"))
+		return nil
+	})
+	session := NewSession(login, LoggedOutIfRedirectedTo("/login-page"))
+
+	ctx, err := NewHTTPContext("POST", ts.URL+"/data", strings.NewReader("payload"), WithSession(session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := func() string {
+		res, err := ctx.DoRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return string(b)
+	}
+
+	if b := body(); b != "private:payload" {
+		t.Errorf("Expected the request to be replayed after logging in, got %q", b)
+	}
+	if atomic.LoadInt32(&logins) != 1 {
+		t.Errorf("Expected 1 login but got %d", logins)
+	}
+	if token := session.Get("token"); token != "token1" {
+		t.Errorf("Expected the login spider to store its token, got %v", token)
+	}
+
+	http.Get(ts.URL + "/expire")
+	ctx.Request().Body, _ = ctx.Request().GetBody()
+	if b := body(); b != "private:payload" {
+		t.Errorf("Expected the request to be replayed after logging in again, got %q", b)
+	}
+	if atomic.LoadInt32(&logins) != 2 {
+		t.Errorf("Expected 2 logins but got %d", logins)
+	}
+}
+
+func TestSessionHeadersScopedToLoginHost(t *testing.T) {
+	var received []http.Header
+	ts := newHeaderEchoServer(&received)
+	defer ts.Close()
+	other := newHeaderEchoServer(&received)
+	defer other.Close()
+
+	login := Get(ts.URL+"/login", func(ctx *Context) error {
+		ctx.Session().SetHeader("Authorization", "Bearer secret")
+		return nil
+	})
+	session := NewSession(login, nil)
+	ctx, _ := NewHTTPContext("GET", ts.URL+"/data", nil, WithSession(session))
+	if err := session.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{ts.URL + "/data", other.URL + "/file"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		ctx.SetRequest(req)
+		if _, err := ctx.DoRequest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if auth := received[0].Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Expected the session header on the login host, got %q", auth)
+	}
+	if auth := received[1].Get("Authorization"); auth != "" {
+		t.Errorf("Expected no session header on another host, got %q", auth)
+	}
+
+	session.Hosts = []string{"127.0.0.1"}
+	req, _ := http.NewRequest("GET", other.URL+"/file", nil)
+	ctx.SetRequest(req)
+	ctx.DoRequest()
+	if auth := received[2].Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Expected the session header on a listed host, got %q", auth)
+	}
+}
+
+func TestSessionStillLoggedOut(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	var logins int
+	login := Get(ts.URL+"/login", func(ctx *Context) error {
+		logins++
+		_, err := ctx.DoRequest()
+		return err
+	})
+	session := NewSession(login, LoggedOutIfStatus(http.StatusUnauthorized))
+	session.MaxRelogins = 2
+
+	ctx, _ := NewHTTPContext("GET", ts.URL, nil, WithSession(session))
+	if _, err := ctx.DoRequest(); err != ErrLoggedOut {
+		t.Errorf("Expected ErrLoggedOut but got %v", err)
+	}
+	if logins != 2 {
+		t.Errorf("Expected 2 logins but got %d", logins)
+	}
+}
+
+func TestLoggedOutIfSelector(t *testing.T) {
+	res := &http.Response{
+		Body: ioutil.NopCloser(strings.NewReader(`<html><form id="login"></form></html>`)),
+	}
+	if !LoggedOutIfSelector("form#login")(res) {
+		t.Error("Expected the login form to be detected")
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if !strings.Contains(string(body), "form") {
+		t.Error("Expected the body to be readable after the detection")
+	}
+}
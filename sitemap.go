@@ -0,0 +1,264 @@
+package spider
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSitemap = errors.New("No sitemap to fetch")
+)
+
+// sitemapLastModKey is the prefix of the key of the State holding the newest lastmod seen by an incremental SitemapSpider.
+const sitemapLastModKey = "sitemap.lastmod"
+
+// SitemapURL is a URL listed in a sitemap.
+type SitemapURL struct {
+	Loc string
+	// LastMod is zero if the sitemap does not tell when the page was modified.
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
+	// Sitemap is the URL of the sitemap listing this URL.
+	Sitemap string
+}
+
+// SitemapFunc is called for each URL of a sitemap with a child Context holding a GET request for it.
+type SitemapFunc func(ctx *Context, u SitemapURL) error
+
+// SitemapSpider is a spider discovering URLs from sitemaps.
+//
+// Sitemaps can be XML sitemaps, gzipped or not, sitemap indexes, whose sitemaps are followed,
+// and robots.txt files, whose Sitemap lines are followed.
+type SitemapSpider struct {
+	// Sitemaps are the URLs of the sitemaps and robots.txt files to read.
+	// A URL whose path is /robots.txt is read as a robots.txt file.
+	Sitemaps []string
+	// Match keeps only the URLs matching it, if set.
+	Match *regexp.Regexp
+	// Since keeps only the URLs modified after it, if set. URLs without lastmod are always kept.
+	Since time.Time
+	// Incremental keeps only the URLs modified since the newest lastmod seen by the previous successful run.
+	// It uses the State of the scheduled entry.
+	Incremental bool
+	// MaxSitemaps limits the number of sitemaps fetched by a run, indexes included. Zero means no limit.
+	// An Incremental run stopped by this limit does not move its watermark, so the next run sees the skipped URLs.
+	MaxSitemaps int
+
+	fn   SitemapFunc
+	opts []Option
+}
+
+// NewSitemapSpider returns a new SitemapSpider reading the provided sitemaps and calling fn for each URL.
+func NewSitemapSpider(sitemaps []string, fn SitemapFunc, opts ...Option) *SitemapSpider {
+	return &SitemapSpider{
+		Sitemaps: sitemaps,
+		fn:       fn,
+		opts:     opts,
+	}
+}
+
+func (s *SitemapSpider) Setup(parent *Context) (*Context, error) {
+	if len(s.Sitemaps) == 0 {
+		return nil, ErrNoSitemap
+	}
+	var opts []Option
+	if parent != nil {
+		opts = parent.Options()
+	}
+	return NewHTTPContext("GET", s.Sitemaps[0], nil, append(opts, s.opts...)...)
+}
+
+// lastModKey returns the key of the State holding the watermark of the spider,
+// so that spiders sharing a State but reading other sitemaps do not move it.
+func (s *SitemapSpider) lastModKey() string {
+	return sitemapLastModKey + ":" + strings.Join(s.Sitemaps, ",")
+}
+
+// Spin fetches the sitemaps and calls the function of the spider for each URL kept by the filters.
+// Each sitemap gets a child Context of ctx and each URL a child Context of its sitemap.
+func (s *SitemapSpider) Spin(ctx *Context) error {
+	since := s.Since
+	var newest time.Time
+	if s.Incremental {
+		if last, err := time.Parse(time.RFC3339Nano, ctx.State().GetString(s.lastModKey())); err == nil && last.After(since) {
+			since = last
+		}
+	}
+
+	queue := append([]string(nil), s.Sitemaps...)
+	seen := make(map[string]bool)
+	truncated := false
+	for fetched := 0; len(queue) > 0; fetched++ {
+		if s.MaxSitemaps > 0 && fetched >= s.MaxSitemaps {
+			truncated = hasUnseen(queue, seen)
+			break
+		}
+		loc := queue[0]
+		queue = queue[1:]
+		if seen[loc] {
+			fetched--
+			continue
+		}
+		seen[loc] = true
+
+		req, err := http.NewRequest("GET", loc, nil)
+		if err != nil {
+			return err
+		}
+		sitemapCtx := ctx.Extend(req)
+		data, err := fetchSitemap(sitemapCtx)
+		if err != nil {
+			return err
+		}
+
+		if req.URL.Path == "/robots.txt" {
+			queue = append(queue, robotsSitemaps(data)...)
+			continue
+		}
+		set, err := parseSitemap(data)
+		if err != nil {
+			return fmt.Errorf("Sitemap %s: %v", loc, err)
+		}
+		for _, child := range set.Sitemaps {
+			queue = append(queue, strings.TrimSpace(child.Loc))
+		}
+		for _, entry := range set.URLs {
+			u := entry.sitemapURL(loc)
+			if u.LastMod.After(newest) {
+				newest = u.LastMod
+			}
+			if u.Loc == "" || (s.Match != nil && !s.Match.MatchString(u.Loc)) {
+				continue
+			}
+			if !since.IsZero() && !u.LastMod.IsZero() && !u.LastMod.After(since) {
+				continue
+			}
+			req, err := http.NewRequest("GET", u.Loc, nil)
+			if err != nil {
+				return err
+			}
+			if err := s.fn(sitemapCtx.Extend(req), u); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.Incremental && !truncated && newest.After(since) {
+		ctx.State().Set(s.lastModKey(), newest.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+func hasUnseen(locs []string, seen map[string]bool) bool {
+	for _, loc := range locs {
+		if !seen[loc] {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchSitemap makes the request of ctx and returns its body, decompressed if it is gzipped.
+func fetchSitemap(ctx *Context) ([]byte, error) {
+	res, err := ctx.DoRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Sitemap %s: %s", ctx.Request().URL, res.Status)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return data, nil
+}
+
+// robotsSitemaps returns the URLs of the Sitemap lines of a robots.txt file.
+func robotsSitemaps(data []byte) []string {
+	var sitemaps []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(strings.TrimSpace(line[:i]), "sitemap") {
+			if loc := strings.TrimSpace(line[i+1:]); loc != "" {
+				sitemaps = append(sitemaps, loc)
+			}
+		}
+	}
+	return sitemaps
+}
+
+// sitemapSet is either a urlset or a sitemapindex.
+type sitemapSet struct {
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc        string  `xml:"loc"`
+	LastMod    string  `xml:"lastmod"`
+	ChangeFreq string  `xml:"changefreq"`
+	Priority   float64 `xml:"priority"`
+}
+
+func parseSitemap(data []byte) (*sitemapSet, error) {
+	var set sitemapSet
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Sitemaps must be UTF-8, accept the ones declaring another charset anyway.
+		return input, nil
+	}
+	if err := decoder.Decode(&set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func (e sitemapEntry) sitemapURL(sitemap string) SitemapURL {
+	return SitemapURL{
+		Loc:        strings.TrimSpace(e.Loc),
+		LastMod:    parseW3CDate(strings.TrimSpace(e.LastMod)),
+		ChangeFreq: strings.TrimSpace(e.ChangeFreq),
+		Priority:   e.Priority,
+		Sitemap:    sitemap,
+	}
+}
+
+// w3cLayouts are the W3C Datetime formats allowed in sitemaps.
+var w3cLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseW3CDate(s string) time.Time {
+	for _, layout := range w3cLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
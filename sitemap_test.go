@@ -0,0 +1,150 @@
+package spider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func sitemapServer(t *testing.T) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nDisallow: /private\nSitemap: %s/sitemap_index.xml\n", ts.URL)
+		case "/sitemap_index.xml":
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>%[1]s/sitemap-posts.xml</loc></sitemap>
+	<sitemap><loc>%[1]s/sitemap-pages.xml.gz</loc></sitemap>
+	<sitemap><loc>%[1]s/sitemap_index.xml</loc></sitemap>
+</sitemapindex>`, ts.URL)
+		case "/sitemap-posts.xml":
+			fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>%[1]s/posts/1</loc><lastmod>2020-01-01</lastmod></url>
+	<url><loc>%[1]s/posts/2</loc><lastmod>2020-03-01T10:00:00+00:00</lastmod><priority>0.8</priority></url>
+</urlset>`, ts.URL)
+		case "/sitemap-pages.xml.gz":
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			fmt.Fprintf(gz, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>%[1]s/about</loc></url>
+</urlset>`, ts.URL)
+			gz.Close()
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Write(buf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return ts
+}
+
+func TestSitemapSpider(t *testing.T) {
+	ts := sitemapServer(t)
+	defer ts.Close()
+
+	var urls []SitemapURL
+	var depths []int
+	s := NewSitemapSpider([]string{ts.URL + "/robots.txt"}, func(ctx *Context, u SitemapURL) error {
+		if ctx.Request().URL.String() != u.Loc {
+			t.Errorf("Expected a request for %s but got %s", u.Loc, ctx.Request().URL)
+		}
+		urls = append(urls, u)
+		depths = append(depths, ctx.Depth())
+		return nil
+	})
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Spin(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{ts.URL + "/posts/1", ts.URL + "/posts/2", ts.URL + "/about"}
+	if len(urls) != len(expected) {
+		t.Fatalf("Expected %d urls but got %v", len(expected), urls)
+	}
+	for i := range expected {
+		if urls[i].Loc != expected[i] || depths[i] != 2 {
+			t.Errorf("Expected %s at depth 2 but got %s at depth %d", expected[i], urls[i].Loc, depths[i])
+		}
+	}
+	if !urls[1].LastMod.Equal(time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)) || urls[1].Priority != 0.8 {
+		t.Errorf("Unexpected sitemap url %+v", urls[1])
+	}
+
+	urls = nil
+	s.Match = regexp.MustCompile("/posts/")
+	s.Since = time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	ctx, _ = s.Setup(nil)
+	if err := s.Spin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Loc != ts.URL+"/posts/2" {
+		t.Errorf("Expected the filters to keep a single url, got %v", urls)
+	}
+}
+
+func TestSitemapSpiderIncremental(t *testing.T) {
+	ts := sitemapServer(t)
+	defer ts.Close()
+
+	var visited []string
+	s := NewSitemapSpider([]string{ts.URL + "/sitemap-posts.xml"}, func(ctx *Context, u SitemapURL) error {
+		visited = append(visited, u.Loc)
+		return nil
+	})
+	s.Incremental = true
+	sched := NewScheduler()
+	e := &Entry{Spider: s}
+	sched.runEntry(e, nil)
+	sched.runEntry(e, nil)
+	if len(visited) != 2 {
+		t.Errorf("Expected the second run to skip the urls not modified since the first one, visited %v", visited)
+	}
+
+	// Another spider sharing the state has its own watermark.
+	visited = nil
+	other := NewSitemapSpider([]string{ts.URL + "/robots.txt"}, s.fn)
+	other.Incremental = true
+	sched.runEntry(&Entry{Spider: other, State: e.State}, nil)
+	if len(visited) != 3 {
+		t.Errorf("Expected another sitemap to ignore the watermark of the first one, visited %v", visited)
+	}
+}
+
+func TestSitemapSpiderIncrementalTruncated(t *testing.T) {
+	ts := sitemapServer(t)
+	defer ts.Close()
+
+	var visited []string
+	s := NewSitemapSpider([]string{ts.URL + "/robots.txt"}, func(ctx *Context, u SitemapURL) error {
+		visited = append(visited, u.Loc)
+		return nil
+	})
+	s.Incremental = true
+	s.MaxSitemaps = 3
+	sched := NewScheduler()
+	e := &Entry{Spider: s}
+	sched.runEntry(e, nil)
+	if _, ok := e.state().Get(s.lastModKey()); ok {
+		t.Error("Expected a truncated run not to move the watermark")
+	}
+
+	visited = nil
+	s.MaxSitemaps = 0
+	sched.runEntry(e, nil)
+	if len(visited) != 3 {
+		t.Errorf("Expected the full run to visit every url, visited %v", visited)
+	}
+	if _, ok := e.state().Get(s.lastModKey()); !ok {
+		t.Error("Expected a complete run to move the watermark")
+	}
+}
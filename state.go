@@ -0,0 +1,135 @@
+package spider
+
+import (
+	"sort"
+	"sync"
+)
+
+// State is the state of a scheduled entry as seen by one of its runs.
+//
+// It is loaded from the State store of the entry when the run starts.
+// Its changes are committed to the store if the run succeeds and discarded if it fails,
+// so that a failed run can be retried from the same state.
+// The changes of the runs of AlwaysOn entries, which end when their stream does, are always committed.
+// When runs of the same entry overlap, the last one to succeed wins.
+type State struct {
+	mu      sync.RWMutex
+	store   Store
+	values  map[string]interface{}
+	changed map[string]bool
+}
+
+// loadState returns a State holding a copy of the values of s.
+func loadState(s Store) *State {
+	st := &State{
+		store:   s,
+		values:  make(map[string]interface{}),
+		changed: make(map[string]bool),
+	}
+	for _, k := range s.Keys() {
+		if v, ok := s.Get(k); ok {
+			st.values[k] = v
+		}
+	}
+	return st
+}
+
+func (s *State) Get(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *State) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.changed[key] = true
+}
+
+func (s *State) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.changed[key] = true
+}
+
+// Keys returns the sorted keys of the state.
+func (s *State) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetString returns the value of key as a string, like Context.GetString.
+func (s *State) GetString(key string) string {
+	v, _ := s.Get(key)
+	return toString(v)
+}
+
+// GetInt returns the value of key as an int, like Context.GetInt.
+func (s *State) GetInt(key string) int {
+	v, _ := s.Get(key)
+	return toInt(v)
+}
+
+// batchStore is a Store applying several changes at once.
+type batchStore interface {
+	update(set map[string]interface{}, deleted []string) error
+}
+
+// commit writes the changes to the store of the state.
+// A FileStore applies them at once and saves its file a single time.
+func (s *State) commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.changed) == 0 {
+		return nil
+	}
+	set := make(map[string]interface{})
+	var deleted []string
+	for k := range s.changed {
+		if v, ok := s.values[k]; ok {
+			set[k] = v
+		} else {
+			deleted = append(deleted, k)
+		}
+	}
+	s.changed = make(map[string]bool)
+	if bs, ok := s.store.(batchStore); ok {
+		return bs.update(set, deleted)
+	}
+	for k, v := range set {
+		s.store.Set(k, v)
+	}
+	for _, k := range deleted {
+		s.store.Delete(k)
+	}
+	return nil
+}
+
+// state returns the store of the state of the entry, creating an in-memory one if it has none.
+func (e *Entry) state() Store {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.State == nil {
+		e.State = NewKVStore()
+	}
+	return e.State
+}
+
+// State returns the state of the entry being run with this context.
+//
+// Outside of a scheduled run, it returns a State kept in memory by the context.
+func (c *Context) State() *State {
+	if c.state == nil {
+		c.state = loadState(NewKVStore())
+	}
+	return c.state
+}
@@ -0,0 +1,101 @@
+package spider
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// incrementalSpider processes the items following the last one seen and fails when fail is set.
+type incrementalSpider struct {
+	fail      bool
+	processed []int
+}
+
+func (s *incrementalSpider) Setup(parent *Context) (*Context, error) { return NewContext(), nil }
+func (s *incrementalSpider) Spin(ctx *Context) error {
+	last := ctx.State().GetInt("last")
+	for id := last + 1; id <= last+2; id++ {
+		s.processed = append(s.processed, id)
+		ctx.State().Set("last", id)
+	}
+	if s.fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestEntryStateCommittedOnSuccess(t *testing.T) {
+	s := &incrementalSpider{}
+	e := &Entry{Spider: s}
+	sched := NewScheduler()
+
+	sched.runEntry(e, nil)
+	s.fail = true
+	sched.runEntry(e, nil)
+	s.fail = false
+	sched.runEntry(e, nil)
+
+	expected := []int{1, 2, 3, 4, 3, 4}
+	if len(s.processed) != len(expected) {
+		t.Fatalf("Expected %v but got %v", expected, s.processed)
+	}
+	for i := range expected {
+		if s.processed[i] != expected[i] {
+			t.Fatalf("Expected %v but got %v", expected, s.processed)
+		}
+	}
+	if v, _ := e.State.Get("last"); v != 4 {
+		t.Errorf("Expected the last successful run to be committed, got %v", v)
+	}
+}
+
+func TestEntryStateSurvivesRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	for i := 0; i < 2; i++ {
+		fs, err := NewFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		NewScheduler().runEntry(&Entry{Spider: &incrementalSpider{}, State: fs}, nil)
+	}
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := fs.Get("last"); toInt(v) != 4 {
+		t.Errorf("Expected the state to be loaded after a restart, got %v", v)
+	}
+}
+
+func TestEntryStateCommitError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(filepath.Join(dir, "missing", "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScheduler().runOnce(&Entry{Spider: &incrementalSpider{}, State: fs}, nil); err == nil {
+		t.Error("Expected the error saving the state to be returned")
+	}
+}
+
+func TestAlwaysOnEntryStateCommitted(t *testing.T) {
+	s := &incrementalSpider{fail: true}
+	e := &Entry{Spider: s, AlwaysOn: true}
+	NewScheduler().runOnce(e, nil)
+	if v, _ := e.State.Get("last"); v != 2 {
+		t.Errorf("Expected the state of an AlwaysOn entry to be committed when it ends, got %v", v)
+	}
+}
@@ -0,0 +1,292 @@
+package spider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Store keeps the values of a Context.
+type Store interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Delete(key string)
+	Keys() []string
+}
+
+// NewKVStore returns a new Store keeping its values in memory.
+func NewKVStore() Store {
+	return &store{
+		kv: make(map[string]interface{}),
+	}
+}
+
+type store struct {
+	sync.RWMutex
+	kv map[string]interface{}
+}
+
+func (s *store) Set(key string, value interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	s.kv[key] = value
+}
+
+func (s *store) Get(key string) (interface{}, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	v, ok := s.kv[key]
+	return v, ok
+}
+
+func (s *store) Delete(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.kv, key)
+}
+
+func (s *store) Keys() []string {
+	s.RLock()
+	defer s.RUnlock()
+	keys := make([]string, 0, len(s.kv))
+	for k := range s.kv {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// FileStore is a Store saving its values in a JSON file after every change,
+// so that they survive across runs and restarts.
+//
+// Values are read back as decoded by encoding/json, with numbers as json.Number:
+// use the typed accessors of Context such as GetInt or GetAs to read them.
+type FileStore struct {
+	Path string
+
+	values store
+	saveMu sync.Mutex
+}
+
+// NewFileStore returns a new FileStore loading the values saved in path, if it exists.
+func NewFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{
+		Path:   path,
+		values: store{kv: make(map[string]interface{})},
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&fs.values.kv); err != nil {
+		return nil, err
+	}
+	if fs.values.kv == nil {
+		fs.values.kv = make(map[string]interface{})
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) Get(key string) (interface{}, bool) {
+	return fs.values.Get(key)
+}
+
+func (fs *FileStore) Keys() []string {
+	return fs.values.Keys()
+}
+
+func (fs *FileStore) Set(key string, value interface{}) {
+	fs.values.Set(key, value)
+	fs.Save()
+}
+
+func (fs *FileStore) Delete(key string) {
+	fs.values.Delete(key)
+	fs.Save()
+}
+
+// update sets and deletes several values and saves the file once.
+func (fs *FileStore) update(set map[string]interface{}, deleted []string) error {
+	fs.values.Lock()
+	for k, v := range set {
+		fs.values.kv[k] = v
+	}
+	for _, k := range deleted {
+		delete(fs.values.kv, k)
+	}
+	fs.values.Unlock()
+	return fs.Save()
+}
+
+// Save writes the values to the file.
+// It is called by Set and Delete, which ignore its error.
+func (fs *FileStore) Save() error {
+	fs.saveMu.Lock()
+	defer fs.saveMu.Unlock()
+	fs.values.RLock()
+	data, err := json.Marshal(fs.values.kv)
+	fs.values.RUnlock()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fs.Path), "store")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), fs.Path)
+}
+
+// WithStore sets the Store of the created contexts.
+// Passed to NewScheduler, it makes every run of the scheduled spiders share the same values.
+func WithStore(s Store) Option {
+	return func(c *config) {
+		c.store = s
+	}
+}
+
+// SetStore replaces the Store of this context.
+func (c *Context) SetStore(s Store) {
+	c.store = s
+}
+
+// Set a value to this context
+func (c *Context) Set(key string, value interface{}) {
+	c.store.Set(key, value)
+}
+
+// Get a value from this context.
+// If the key has not been set in this context, the value is looked up in its parents.
+func (c *Context) Get(key string) interface{} {
+	v, _ := c.Lookup(key)
+	return v
+}
+
+// Lookup returns the value of key in this context or in the nearest parent having it.
+// The boolean reports whether the key has been found.
+func (c *Context) Lookup(key string) (interface{}, bool) {
+	for ctx := c; ctx != nil; ctx = ctx.parent() {
+		if v, ok := ctx.store.Get(key); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Delete removes a value from this context. The values of its parents are untouched.
+func (c *Context) Delete(key string) {
+	c.store.Delete(key)
+}
+
+// Keys returns the sorted keys visible from this context, including the ones of its parents.
+func (c *Context) Keys() []string {
+	snapshot := c.Snapshot()
+	keys := make([]string, 0, len(snapshot))
+	for k := range snapshot {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Snapshot returns a copy of the values visible from this context.
+// The values of this context take precedence over the ones of its parents.
+func (c *Context) Snapshot() map[string]interface{} {
+	snapshot := make(map[string]interface{})
+	for ctx := c; ctx != nil; ctx = ctx.parent() {
+		for _, k := range ctx.store.Keys() {
+			if _, ok := snapshot[k]; ok {
+				continue
+			}
+			if v, ok := ctx.store.Get(k); ok {
+				snapshot[k] = v
+			}
+		}
+	}
+	return snapshot
+}
+
+// GetAs returns the value of key looked up in ctx and its parents if it is of type T.
+// Values loaded from a FileStore have the types of encoding/json, numbers are json.Number for instance.
+func GetAs[T any](ctx *Context, key string) (T, bool) {
+	v, ok := ctx.Lookup(key)
+	t, isT := v.(T)
+	return t, ok && isT
+}
+
+// GetString returns the value of key as a string.
+// It returns an empty string if the key is missing or is not a string, a []byte or a fmt.Stringer.
+func (c *Context) GetString(key string) string {
+	return toString(c.Get(key))
+}
+
+// GetInt returns the value of key as an int.
+// Integers, floats, json.Number and numeric strings are converted, other values return 0.
+func (c *Context) GetInt(key string) int {
+	return toInt(c.Get(key))
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return ""
+}
+
+func toInt(v interface{}) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint:
+		return int(v)
+	case uint8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		return int(v)
+	case uint64:
+		return int(v)
+	case float32:
+		return int(v)
+	case float64:
+		return int(v)
+	case json.Number:
+		n, _ := strconv.Atoi(string(v))
+		return n
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
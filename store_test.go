@@ -0,0 +1,110 @@
+package spider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestStoreLookupThroughParents(t *testing.T) {
+	root := NewContext()
+	root.Set("id", 42)
+	root.Set("name", "root")
+	child := NewContext()
+	child.SetParent(root)
+	child.Set("name", "child")
+
+	if child.GetInt("id") != 42 || child.GetString("name") != "child" || root.GetString("name") != "root" {
+		t.Error("Expected the child to override the values of its parent")
+	}
+	if v, ok := GetAs[int](child, "id"); !ok || v != 42 {
+		t.Errorf("Expected 42 but got %v", v)
+	}
+	if _, ok := GetAs[string](child, "id"); ok {
+		t.Error("Expected GetAs to fail on a value of another type")
+	}
+	if keys := child.Keys(); !reflect.DeepEqual(keys, []string{"id", "name"}) {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+
+	child.Delete("name")
+	if child.GetString("name") != "root" {
+		t.Error("Expected the value of the parent once the one of the child is deleted")
+	}
+	snapshot := child.Snapshot()
+	snapshot["id"] = 0
+	if child.GetInt("id") != 42 {
+		t.Error("Expected the snapshot to be a copy")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext()
+	ctx.SetStore(fs)
+	ctx.Set("last-id", 1234)
+	ctx.Set("cursor", "abc")
+
+	fs, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err = NewHTTPContext("GET", "http://example.com", nil, WithStore(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.GetInt("last-id") != 1234 || ctx.GetString("cursor") != "abc" {
+		t.Errorf("Expected the values to be loaded from the file, got %v", ctx.Snapshot())
+	}
+}
+
+func TestFileStoreNumbersAndConcurrentSets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fs.Set(strconv.Itoa(i), i)
+		}(i)
+	}
+	wg.Wait()
+	fs.Set("id", int64(1<<62+1))
+
+	fs, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fs.Keys()); n != 21 {
+		t.Errorf("Expected 21 values to be saved but got %d", n)
+	}
+	ctx := NewContext()
+	ctx.SetStore(fs)
+	if id := ctx.GetInt("id"); id != 1<<62+1 {
+		t.Errorf("Expected large integers to be kept exactly, got %d", id)
+	}
+}
@@ -0,0 +1,339 @@
+package spider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bitly/go-simplejson"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	ErrStreamClosed        = errors.New("Stream closed by the server")
+	ErrUpgradeNotSupported = errors.New("The transport does not support WebSocket connections")
+)
+
+// StreamMessage is a message received from a stream.
+type StreamMessage struct {
+	// Event is the type of an SSE event, "message" by default, and "message" for WebSocket messages.
+	Event string
+	// ID is the id of an SSE event.
+	ID   string
+	Data []byte
+	// Retry is the reconnection time in milliseconds sent by an SSE server, if any.
+	Retry int
+}
+
+// JSON parses the data of the message as JSON.
+func (m StreamMessage) JSON() (*simplejson.Json, error) {
+	return simplejson.NewJson(m.Data)
+}
+
+// StreamFunc is called with the Context of the stream for each message received.
+// An error closes the connection and is returned by Spin.
+type StreamFunc func(ctx *Context, msg StreamMessage) error
+
+// StreamSpider is a long-lived spider receiving the messages of a Server-Sent Events or a WebSocket stream.
+//
+// Its Spin connects to the stream and returns when the connection ends, with ErrStreamClosed if the server closed it,
+// or when the scheduler is stopped. WithCache, WithChangeDetection, WithRenderer and WithTimeout do not apply to streams,
+// and the LoggedOutFunc of a Session only sees the status and the headers of their responses.
+// The WebSocket handshake is sent by the http.Client of the context like any other request,
+// which requires a transport supporting protocol upgrades such as *http.Transport.
+// Add it to a scheduler with AddAlwaysOn to reconnect it with a backoff.
+type StreamSpider struct {
+	URL string
+	// Messages are sent to a WebSocket after each connection, to subscribe to channels for instance.
+	Messages [][]byte
+
+	websocket bool
+	fn        StreamFunc
+	opts      []Option
+
+	mu          sync.Mutex
+	lastEventID string
+}
+
+// SSE returns a new StreamSpider receiving the events of a Server-Sent Events stream.
+// The id of the last event received is sent in the Last-Event-ID header when reconnecting.
+func SSE(url string, fn StreamFunc, opts ...Option) *StreamSpider {
+	return &StreamSpider{URL: url, fn: fn, opts: opts}
+}
+
+// WebSocket returns a new StreamSpider receiving the messages of a WebSocket, url being a ws:// or wss:// URL.
+func WebSocket(url string, fn StreamFunc, opts ...Option) *StreamSpider {
+	return &StreamSpider{URL: url, websocket: true, fn: fn, opts: opts}
+}
+
+func (s *StreamSpider) Setup(parent *Context) (*Context, error) {
+	var opts []Option
+	if parent != nil {
+		opts = parent.Options()
+	}
+	opts = append(append(opts, s.opts...), streamOption)
+	ctx, err := NewHTTPContext("GET", s.URL, nil, opts...)
+	if err != nil {
+		return ctx, err
+	}
+	ctx.stream = true
+	req := ctx.Request()
+	req.Header.Del("Content-Type")
+	if !s.websocket {
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Cache-Control", "no-cache")
+	}
+	return ctx, nil
+}
+
+// streamOption disables the options reading a whole response or bounding its duration,
+// which would never deliver or would cut an endless stream.
+func streamOption(c *config) {
+	c.cache = nil
+	c.changeDetector = nil
+	c.renderer = nil
+	c.timeout = 0
+}
+
+// Spin connects to the stream and calls the function of the spider for each message until the connection ends.
+func (s *StreamSpider) Spin(ctx *Context) error {
+	if s.websocket {
+		return s.spinWebSocket(ctx)
+	}
+	return s.spinSSE(ctx)
+}
+
+func (s *StreamSpider) spinSSE(ctx *Context) error {
+	cctx, cancel := context.WithCancel(ctx.Request().Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-cctx.Done():
+		}
+	}()
+	req := ctx.Request().WithContext(cctx)
+	s.mu.Lock()
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+	s.mu.Unlock()
+	ctx.SetRequest(req)
+
+	res, err := ctx.DoRequest()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Stream %s: %s", s.URL, res.Status)
+	}
+
+	err = readSSE(res.Body, func(msg StreamMessage) error {
+		if msg.ID != "" {
+			s.mu.Lock()
+			s.lastEventID = msg.ID
+			s.mu.Unlock()
+		}
+		return s.fn(ctx, msg)
+	})
+	if stopped(ctx) {
+		return nil
+	}
+	return err
+}
+
+// readSSE parses a Server-Sent Events stream and calls fn for each event.
+// It returns ErrStreamClosed at the end of the stream.
+func readSSE(r io.Reader, fn func(StreamMessage) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	var (
+		msg  StreamMessage
+		data []string
+	)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			if len(data) > 0 {
+				msg.Data = []byte(strings.Join(data, "\n"))
+				if msg.Event == "" {
+					msg.Event = "message"
+				}
+				if err := fn(msg); err != nil {
+					return err
+				}
+			}
+			msg = StreamMessage{ID: msg.ID}
+			data = nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			msg.Event = value
+		case "id":
+			msg.ID = value
+		case "retry":
+			msg.Retry, _ = strconv.Atoi(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return ErrStreamClosed
+}
+
+func (s *StreamSpider) spinWebSocket(ctx *Context) error {
+	req := ctx.Request()
+	origin := *req.URL
+	origin.Scheme = "http"
+	if req.URL.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	origin.Path, origin.RawQuery = "/", ""
+	config, err := websocket.NewConfig(req.URL.String(), origin.String())
+	if err != nil {
+		return err
+	}
+	ws, err := websocket.NewClient(config, &upgradeConn{ctx: ctx, scheme: origin.Scheme})
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-finished:
+		}
+	}()
+
+	for _, m := range s.Messages {
+		if err := websocket.Message.Send(ws, string(m)); err != nil {
+			return err
+		}
+	}
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			if stopped(ctx) {
+				return nil
+			}
+			if err == io.EOF {
+				return ErrStreamClosed
+			}
+			return err
+		}
+		if err := s.fn(ctx, StreamMessage{Event: "message", Data: data}); err != nil {
+			return err
+		}
+	}
+}
+
+// upgradeConn sends the handshake written by a WebSocket client with the http.Client of a context,
+// so that its proxies, TLS configuration, headers, cookies and circuit breaker apply,
+// and then carries the frames over the upgraded connection.
+type upgradeConn struct {
+	ctx    *Context
+	scheme string
+
+	handshake bytes.Buffer
+	r         io.Reader
+	conn      io.ReadWriteCloser
+}
+
+func (u *upgradeConn) Write(p []byte) (int, error) {
+	if u.conn != nil {
+		return u.conn.Write(p)
+	}
+	u.handshake.Write(p)
+	if !bytes.Contains(u.handshake.Bytes(), []byte("\r\n\r\n")) {
+		return len(p), nil
+	}
+	if err := u.upgrade(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (u *upgradeConn) Read(p []byte) (int, error) {
+	if u.r == nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return u.r.Read(p)
+}
+
+func (u *upgradeConn) Close() error {
+	if u.conn == nil {
+		return nil
+	}
+	return u.conn.Close()
+}
+
+// upgrade sends the request of the context with the WebSocket headers of the handshake
+// and replays the head of the response to the WebSocket client.
+func (u *upgradeConn) upgrade() error {
+	handshake, err := http.ReadRequest(bufio.NewReader(&u.handshake))
+	if err != nil {
+		return err
+	}
+	req := u.ctx.Request().Clone(u.ctx.Request().Context())
+	req.URL.Scheme = u.scheme
+	for k, v := range handshake.Header {
+		if k == "Host" {
+			continue
+		}
+		req.Header[k] = v
+	}
+	// The connection is kept open to carry the frames.
+	req.Close = false
+	u.ctx.SetRequest(req)
+	res, err := u.ctx.DoRequest()
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		res.Body.Close()
+		return fmt.Errorf("WebSocket %s: %s", req.URL, res.Status)
+	}
+	conn, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		res.Body.Close()
+		return ErrUpgradeNotSupported
+	}
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "HTTP/1.1 %s\r\n", res.Status)
+	res.Header.Write(&head)
+	head.WriteString("\r\n")
+	u.conn = conn
+	u.r = io.MultiReader(&head, conn)
+	return nil
+}
+
+func stopped(ctx *Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,284 @@
+package spider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"golang.org/x/net/websocket"
+)
+
+func TestReadSSE(t *testing.T) {
+	stream := ": comment\r\nevent: update\r\nid: 1\r\ndata: first\r\ndata: line\r\n\r\nretry: 3000\ndata:second\n\ndata\n\n"
+	var msgs []StreamMessage
+	err := readSSE(strings.NewReader(stream), func(msg StreamMessage) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != ErrStreamClosed {
+		t.Errorf("Expected ErrStreamClosed at the end of the stream, got %v", err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 events but got %d", len(msgs))
+	}
+	if msgs[0].Event != "update" || msgs[0].ID != "1" || string(msgs[0].Data) != "first\nline" {
+		t.Errorf("Unexpected first event %+v", msgs[0])
+	}
+	if msgs[1].Event != "message" || msgs[1].ID != "1" || msgs[1].Retry != 3000 || string(msgs[1].Data) != "second" {
+		t.Errorf("Unexpected second event %+v", msgs[1])
+	}
+	if len(msgs[2].Data) != 0 || msgs[2].Event != "message" {
+		t.Errorf("Unexpected third event %+v", msgs[2])
+	}
+}
+
+func TestSSESpiderReconnects(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		lastEventID []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastEventID = append(lastEventID, r.Header.Get("Last-Event-ID"))
+		n := len(lastEventID)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "id: %d\ndata: {\"n\": %d}\n\n", n, n)
+	}))
+	defer ts.Close()
+
+	received := make(chan int, 10)
+	s := SSE(ts.URL, func(ctx *Context, msg StreamMessage) error {
+		js, err := msg.JSON()
+		if err != nil {
+			return err
+		}
+		received <- js.Get("n").MustInt()
+		return nil
+	})
+	sched := NewScheduler()
+	e := &Entry{
+		Spider:   s,
+		AlwaysOn: true,
+		Retry:    &RunRetry{NewBackOff: func() backoff.BackOff { return backoff.NewConstantBackOff(time.Millisecond) }},
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		sched.supervise(e, done)
+		close(finished)
+	}()
+	for i := 1; i <= 3; i++ {
+		select {
+		case n := <-received:
+			if n != i {
+				t.Errorf("Expected message %d but got %d", i, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the stream to reconnect")
+		}
+	}
+	close(done)
+	<-finished
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lastEventID[0] != "" || lastEventID[1] != "1" || lastEventID[2] != "2" {
+		t.Errorf("Expected the last event id to be sent on reconnection, got %q", lastEventID)
+	}
+	if history := e.History(); len(history) < 3 || history[0].Err != ErrStreamClosed {
+		t.Errorf("Expected each connection to be recorded as a run, got %+v", history)
+	}
+}
+
+func TestWebSocketSpider(t *testing.T) {
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var subscription string
+		if err := websocket.Message.Receive(ws, &subscription); err != nil {
+			return
+		}
+		websocket.Message.Send(ws, fmt.Sprintf(`{"subscribed": %q}`, subscription))
+		websocket.Message.Send(ws, `{"price": 42}`)
+		// Keep the connection open until the client closes it.
+		var ignored string
+		websocket.Message.Receive(ws, &ignored)
+	}))
+	defer ts.Close()
+
+	done := make(chan struct{})
+	var msgs []string
+	s := WebSocket("ws"+strings.TrimPrefix(ts.URL, "http"), func(ctx *Context, msg StreamMessage) error {
+		msgs = append(msgs, string(msg.Data))
+		if len(msgs) == 2 {
+			close(done)
+		}
+		return nil
+	})
+	s.Messages = [][]byte{[]byte("prices")}
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.done = done
+	if err := s.Spin(ctx); err != nil {
+		t.Errorf("Expected Spin to return without error once stopped, got %v", err)
+	}
+	if len(msgs) != 2 || msgs[0] != `{"subscribed": "prices"}` || msgs[1] != `{"price": 42}` {
+		t.Errorf("Unexpected messages %q", msgs)
+	}
+}
+
+func TestWebSocketSpiderUsesClient(t *testing.T) {
+	ts := httptest.NewTLSServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.Message.Send(ws, ws.Request().Header.Get("X-Token"))
+	}))
+	defer ts.Close()
+
+	var msgs []string
+	s := WebSocket("wss"+strings.TrimPrefix(ts.URL, "https"), func(ctx *Context, msg StreamMessage) error {
+		msgs = append(msgs, string(msg.Data))
+		return nil
+	},
+		WithTLSConfig(ts.Client().Transport.(*http.Transport).TLSClientConfig),
+		WithHeaders(http.Header{"X-Token": {"secret"}}),
+	)
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Spin(ctx); err != ErrStreamClosed {
+		t.Errorf("Expected ErrStreamClosed but got %v", err)
+	}
+	if len(msgs) != 1 || msgs[0] != "secret" {
+		t.Errorf("Expected the handshake to use the TLS configuration and the headers, got %q", msgs)
+	}
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.Host
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	s = WebSocket("ws://example.com/prices", nil, WithProxy(proxyURL))
+	ctx, err = s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Spin(ctx); err == nil || proxied != "example.com" {
+		t.Errorf("Expected the handshake to go through the proxy, got %v", err)
+	}
+}
+
+func TestSSESpiderWithSession(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	received := make(chan string, 1)
+	session := NewSession(Get(ts.URL+"/login", func(ctx *Context) error { return nil }), LoggedOutIfSelector("form"))
+	s := SSE(ts.URL, func(ctx *Context, msg StreamMessage) error {
+		received <- string(msg.Data)
+		return nil
+	}, WithSession(session))
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ctx.done = done
+	go s.Spin(ctx)
+	select {
+	case data := <-received:
+		if data != "first" {
+			t.Errorf("Unexpected event %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the logged out detection not to read the stream")
+	}
+}
+
+func TestSSESpiderIgnoresBufferingOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	received := make(chan string, 1)
+	s := SSE(ts.URL, func(ctx *Context, msg StreamMessage) error {
+		received <- string(msg.Data)
+		return nil
+	},
+		WithCache(NewMemoryCache()),
+		WithChangeDetection(NewFingerprintStore(NewMemoryCache()), ""),
+		WithTimeout(50*time.Millisecond),
+	)
+	ctx, err := s.Setup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	ctx.done = done
+	result := make(chan error, 1)
+	go func() {
+		result <- s.Spin(ctx)
+	}()
+	select {
+	case data := <-received:
+		if data != "first" {
+			t.Errorf("Unexpected event %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the event")
+	}
+	select {
+	case err := <-result:
+		t.Fatalf("Expected the stream to outlive the client timeout, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(done)
+	if err := <-result; err != nil {
+		t.Errorf("Expected a stopped stream to return nil, got %v", err)
+	}
+}
+
+// blockingSpider signals each run and blocks until the scheduler stops.
+type blockingSpider struct {
+	runs chan struct{}
+}
+
+func (s *blockingSpider) Setup(parent *Context) (*Context, error) { return NewContext(), nil }
+func (s *blockingSpider) Spin(ctx *Context) error {
+	s.runs <- struct{}{}
+	<-ctx.Done()
+	return nil
+}
+
+func TestAlwaysOnRestartedByStart(t *testing.T) {
+	s := &blockingSpider{runs: make(chan struct{}, 2)}
+	sched := NewScheduler()
+	sched.AddAlwaysOn(s)
+	for i := 0; i < 2; i++ {
+		sched.Start()
+		select {
+		case <-s.runs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Start %d: expected the always-on spider to run", i+1)
+		}
+		sched.Stop()
+	}
+}
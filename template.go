@@ -0,0 +1,186 @@
+package spider
+
+import (
+	"bytes"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// templateSpider is an HTTP spider whose url and body are rendered at each Setup.
+type templateSpider struct {
+	method string
+	url    *template.Template
+	body   *template.Template
+	err    error
+	fn     spinFunc
+	opts   []Option
+
+	mu       sync.Mutex
+	counters map[string]int
+	// pending holds the counters used by the contexts set up and not spun yet.
+	pending map[*Context]map[string]int
+}
+
+// NewHTTPTemplateSpider creates a new spider whose url and body are text/template templates
+// rendered at each Setup. An empty body template sends no body.
+//
+// The templates are executed with the values of the store of the root of the Context passed to Setup,
+// so {{.id}} is replaced by the value set with ctx.Set("id", ...). The following functions are available:
+//
+//	now                 the current time
+//	date "2006-01-02" t formats t with the layout, t defaults to now when omitted
+//	addDays n t         returns t shifted by n days
+//	counter "page"      a counter of the spider incremented after each successful run, starting at 1
+//	add a b             a + b
+//	pathEscape s        escapes s to be used as a path segment
+//	urlquery s          escapes s to be used in a query string (text/template builtin)
+//
+// Values are inserted as is: use pathEscape or urlquery for values that can contain reserved characters,
+// like {{.name | urlquery}}.
+// A counter returns the same value everywhere it is used during a Setup.
+// It only advances when Spin succeeds, so a failed run that is retried uses the same value again.
+// Parse errors are returned by Setup, as well as execution errors like a missing value.
+func NewHTTPTemplateSpider(method, url, body string, fn spinFunc, opts ...Option) *templateSpider {
+	s := &templateSpider{
+		method:   method,
+		fn:       fn,
+		opts:     opts,
+		counters: make(map[string]int),
+		pending:  make(map[*Context]map[string]int),
+	}
+	s.url, s.err = parseTemplate("url", url)
+	if s.err == nil && body != "" {
+		s.body, s.err = parseTemplate("body", body)
+	}
+	return s
+}
+
+// GetTemplate returns a new GET HTTP Spider whose url is a template.
+func GetTemplate(url string, fn spinFunc, opts ...Option) *templateSpider {
+	return NewHTTPTemplateSpider("GET", url, "", fn, opts...)
+}
+
+// PostTemplate returns a new POST HTTP Spider whose url and body are templates.
+func PostTemplate(url, body string, fn spinFunc, opts ...Option) *templateSpider {
+	return NewHTTPTemplateSpider("POST", url, body, fn, opts...)
+}
+
+// PutTemplate returns a new PUT HTTP Spider whose url and body are templates.
+func PutTemplate(url, body string, fn spinFunc, opts ...Option) *templateSpider {
+	return NewHTTPTemplateSpider("PUT", url, body, fn, opts...)
+}
+
+// DeleteTemplate returns a new DELETE HTTP Spider whose url is a template.
+func DeleteTemplate(url string, fn spinFunc, opts ...Option) *templateSpider {
+	return NewHTTPTemplateSpider("DELETE", url, "", fn, opts...)
+}
+
+func (s *templateSpider) Setup(parent *Context) (*Context, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var (
+		opts []Option
+		vars map[string]interface{}
+	)
+	if parent != nil {
+		opts = parent.Options()
+		vars = parent.Root().Snapshot()
+	}
+
+	counter, used := s.counter()
+	funcs := templateFuncs(counter)
+	url, err := render(s.url, funcs, vars)
+	if err != nil {
+		return nil, err
+	}
+	var body Body
+	if s.body != nil {
+		data, err := render(s.body, funcs, vars)
+		if err != nil {
+			return nil, err
+		}
+		body = BytesBody([]byte(data), "")
+	}
+	ctx, err := newBodyContext(s.method, url, body, opts, s.opts)
+	if err == nil && len(used) > 0 {
+		s.mu.Lock()
+		s.pending[ctx] = used
+		s.mu.Unlock()
+	}
+	return ctx, err
+}
+
+// Spin runs the spider and advances the counters used by the Setup of ctx if it succeeds.
+func (s *templateSpider) Spin(ctx *Context) error {
+	s.mu.Lock()
+	used := s.pending[ctx]
+	delete(s.pending, ctx)
+	s.mu.Unlock()
+
+	if err := s.fn(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for name, v := range used {
+		if v > s.counters[name] {
+			s.counters[name] = v
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// counter returns the counter function for a single Setup and the values it returned.
+// Each counter is the value following the last successful run.
+func (s *templateSpider) counter() (func(string) int, map[string]int) {
+	used := make(map[string]int)
+	return func(name string) int {
+		if v, ok := used[name]; ok {
+			return v
+		}
+		s.mu.Lock()
+		v := s.counters[name] + 1
+		s.mu.Unlock()
+		used[name] = v
+		return v
+	}, used
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Funcs(templateFuncs(nil)).Parse(text)
+}
+
+func render(t *template.Template, funcs template.FuncMap, vars map[string]interface{}) (string, error) {
+	t, err := t.Clone()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Funcs(funcs).Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func templateFuncs(counter func(string) int) template.FuncMap {
+	return template.FuncMap{
+		"now": time.Now,
+		"date": func(layout string, t ...time.Time) string {
+			if len(t) == 0 {
+				return time.Now().Format(layout)
+			}
+			return t[0].Format(layout)
+		},
+		"addDays": func(n int, t time.Time) time.Time {
+			return t.AddDate(0, 0, n)
+		},
+		"counter": counter,
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"pathEscape": url.PathEscape,
+	}
+}
@@ -0,0 +1,122 @@
+package spider
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateSpider(t *testing.T) {
+	var urls, bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		urls = append(urls, r.URL.String())
+		bodies = append(bodies, string(data))
+	}))
+	defer ts.Close()
+
+	s := PostTemplate(
+		ts.URL+`/users/{{.user}}?page={{counter "page"}}&day={{date "2006-01-02"}}`,
+		`{"page":{{counter "page"}},"next":{{add (counter "page") 1}}}`,
+		func(ctx *Context) error {
+			_, err := ctx.DoRequest()
+			return err
+		},
+	)
+	root := NewContext()
+	root.Set("user", "gopher")
+	child := NewContext()
+	child.SetParent(root)
+	for i := 0; i < 2; i++ {
+		ctx, err := s.Setup(child)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Spin(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	day := time.Now().Format("2006-01-02")
+	expectedURLs := []string{"/users/gopher?page=1&day=" + day, "/users/gopher?page=2&day=" + day}
+	expectedBodies := []string{`{"page":1,"next":2}`, `{"page":2,"next":3}`}
+	for i := range expectedURLs {
+		if urls[i] != expectedURLs[i] {
+			t.Errorf("Expected url %q but got %q", expectedURLs[i], urls[i])
+		}
+		if bodies[i] != expectedBodies[i] {
+			t.Errorf("Expected body %q but got %q", expectedBodies[i], bodies[i])
+		}
+	}
+}
+
+func TestTemplateSpiderParseError(t *testing.T) {
+	s := GetTemplate("http://example.com/{{.id", nil)
+	if _, err := s.Setup(nil); err == nil {
+		t.Error("Expected the parse error to be returned by Setup")
+	}
+}
+
+func TestTemplateSpiderMissingValue(t *testing.T) {
+	s := GetTemplate("http://example.com/users/{{.id}}", nil)
+	if _, err := s.Setup(nil); err == nil {
+		t.Error("Expected an error without parent")
+	}
+	if _, err := s.Setup(NewContext()); err == nil {
+		t.Error("Expected an error for a missing value")
+	}
+}
+
+func TestTemplateSpiderEscaping(t *testing.T) {
+	s := GetTemplate("http://example.com/users/{{.name | pathEscape}}?q={{.q | urlquery}}", nil)
+	parent := NewContext()
+	parent.Set("name", "a b/c")
+	parent.Set("q", "x&y=z")
+	ctx, err := s.Setup(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := ctx.Request().URL
+	if u.EscapedPath() != "/users/a%20b%2Fc" {
+		t.Errorf("Expected an escaped path but got %q", u.EscapedPath())
+	}
+	if q := u.Query().Get("q"); q != "x&y=z" {
+		t.Errorf("Expected the query value %q but got %q", "x&y=z", q)
+	}
+}
+
+func TestTemplateSpiderCounterRetried(t *testing.T) {
+	var pages []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages = append(pages, r.URL.Query().Get("page"))
+		if len(pages) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	s := GetTemplate(ts.URL+`/?page={{counter "page"}}`, func(ctx *Context) error {
+		res, err := ctx.DoRequest()
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			return errors.New(res.Status)
+		}
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		ctx, err := s.Setup(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Spin(ctx)
+	}
+	if strings.Join(pages, ",") != "1,2,2" {
+		t.Errorf("Expected the failed page to be requested again, got %q", pages)
+	}
+}
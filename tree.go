@@ -0,0 +1,102 @@
+package spider
+
+import (
+	"errors"
+	"sync"
+)
+
+// SkipChildren is returned by a WalkFunc to skip the children of the current context.
+var SkipChildren = errors.New("Skip children")
+
+// treeMu guards the Parent and Children links of every Context.
+var treeMu sync.RWMutex
+
+// WalkFunc is called by Walk for every context of a tree with its depth relative to the walked context.
+type WalkFunc func(ctx *Context, depth int) error
+
+func (c *Context) parent() *Context {
+	treeMu.RLock()
+	defer treeMu.RUnlock()
+	return c.Parent
+}
+
+func (c *Context) children() []*Context {
+	treeMu.RLock()
+	defer treeMu.RUnlock()
+	return append([]*Context(nil), c.Children...)
+}
+
+// Root returns the topmost parent of this context, or the context itself if it has no parent.
+func (c *Context) Root() *Context {
+	root := c
+	for p := c.parent(); p != nil; p = p.parent() {
+		root = p
+	}
+	return root
+}
+
+// Depth returns the number of parents of this context.
+func (c *Context) Depth() int {
+	var depth int
+	for p := c.parent(); p != nil; p = p.parent() {
+		depth++
+	}
+	return depth
+}
+
+// Path returns the contexts from the root to this context included.
+func (c *Context) Path() []*Context {
+	path := make([]*Context, c.Depth()+1)
+	for i, ctx := len(path)-1, c; ctx != nil; i, ctx = i-1, ctx.parent() {
+		path[i] = ctx
+	}
+	return path
+}
+
+// Walk calls fn for this context and all its descendants, depth first, parents before their children.
+//
+// If fn returns SkipChildren, the children of the current context are skipped.
+// Any other error stops the walk and is returned by Walk.
+func (c *Context) Walk(fn WalkFunc) error {
+	err := c.walk(fn, 0)
+	if err == SkipChildren {
+		return nil
+	}
+	return err
+}
+
+func (c *Context) walk(fn WalkFunc, depth int) error {
+	if err := fn(c, depth); err != nil {
+		return err
+	}
+	for _, child := range c.children() {
+		if err := child.walk(fn, depth+1); err != nil && err != SkipChildren {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContextNode is a serializable view of a Context and its descendants.
+type ContextNode struct {
+	Method   string         `json:"method,omitempty"`
+	URL      string         `json:"url,omitempty"`
+	Status   int            `json:"status,omitempty"`
+	Children []*ContextNode `json:"children,omitempty"`
+}
+
+// Tree returns a ContextNode describing the requests and responses of this context and its descendants.
+func (c *Context) Tree() *ContextNode {
+	node := &ContextNode{}
+	if req := c.Request(); req != nil {
+		node.Method = req.Method
+		node.URL = req.URL.String()
+	}
+	if res := c.Response(); res != nil {
+		node.Status = res.StatusCode
+	}
+	for _, child := range c.children() {
+		node.Children = append(node.Children, child.Tree())
+	}
+	return node
+}
@@ -0,0 +1,148 @@
+package spider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtend(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			return
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	ctx, err := NewHTTPContext("GET", ts.URL+"/login", nil, WithHeaders(http.Header{"X-Token": {"secret"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.DoRequest(); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Set("shared", 1)
+
+	req, _ := http.NewRequest("GET", ts.URL+"/private", nil)
+	child := ctx.Extend(req)
+	child.Set("own", 2)
+	if child.Client != ctx.Client || child.Parent != ctx || len(ctx.Children) != 1 {
+		t.Fatal("Expected the child to be linked to its parent and share its client")
+	}
+	if child.Get("shared") != 1 || ctx.Get("own") != nil {
+		t.Error("Expected the child to see the values of its parent but not the opposite")
+	}
+	res, err := child.DoRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected the child to inherit cookies and headers, got status %d", res.StatusCode)
+	}
+}
+
+func TestExtendOtherHost(t *testing.T) {
+	parent, err := NewHTTPContext("GET", "http://example.com/", nil,
+		WithHeaders(http.Header{"Authorization": {"Bearer secret"}, "Accept": {"text/html"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent.Request().Header.Set("X-Api-Key", "secret")
+	parent.Request().Header.Set("Proxy-Authorization", "Basic secret")
+
+	same := parent.Extend(mustRequest(t, "http://example.com/next"))
+	if same.Request().Header.Get("Authorization") == "" || same.Request().Header.Get("X-Api-Key") == "" {
+		t.Errorf("Expected the headers to be inherited on the same host, got %v", same.Request().Header)
+	}
+
+	other := parent.Extend(mustRequest(t, "http://cdn.example.org/file"))
+	h := other.Request().Header
+	for _, k := range []string{"Authorization", "Proxy-Authorization", "X-Api-Key"} {
+		if v := h.Get(k); v != "" {
+			t.Errorf("Expected %s not to be sent to another host, got %q", k, v)
+		}
+	}
+	if h.Get("Accept") != "text/html" || h.Get("User-Agent") == "" {
+		t.Errorf("Expected the headers of the options to be kept, got %v", h)
+	}
+}
+
+func TestExtendWithRequestCompat(t *testing.T) {
+	parent, _ := NewHTTPContext("GET", "http://example.com/", nil)
+	child := parent.ExtendWithRequest(*NewContext(), mustRequest(t, "http://example.com/page"))
+	if child.Parent != parent || child.Request().URL.Path != "/page" {
+		t.Error("Expected ExtendWithRequest to create a child of the context")
+	}
+}
+
+func TestContextTree(t *testing.T) {
+	root := NewContext()
+	a, b, c := NewContext(), NewContext(), NewContext()
+	a.SetParent(root)
+	b.SetParent(root)
+	c.SetParent(a)
+
+	if c.Root() != root || root.Root() != root {
+		t.Error("Unexpected root")
+	}
+	if c.Depth() != 2 || root.Depth() != 0 {
+		t.Errorf("Unexpected depths %d and %d", c.Depth(), root.Depth())
+	}
+	if path := c.Path(); len(path) != 3 || path[0] != root || path[1] != a || path[2] != c {
+		t.Errorf("Unexpected path %v", path)
+	}
+
+	var visited []*Context
+	var depths []int
+	root.Walk(func(ctx *Context, depth int) error {
+		visited = append(visited, ctx)
+		depths = append(depths, depth)
+		return nil
+	})
+	if len(visited) != 4 || visited[1] != a || visited[2] != c || visited[3] != b || depths[2] != 2 {
+		t.Errorf("Unexpected walk order %v", depths)
+	}
+
+	visited = nil
+	root.Walk(func(ctx *Context, depth int) error {
+		visited = append(visited, ctx)
+		if ctx == a {
+			return SkipChildren
+		}
+		return nil
+	})
+	if len(visited) != 3 {
+		t.Errorf("Expected the children of a to be skipped, visited %d contexts", len(visited))
+	}
+
+	c.SetParent(b)
+	if len(a.Children) != 0 || len(b.Children) != 1 {
+		t.Error("Expected SetParent to move the context to its new parent")
+	}
+}
+
+func TestContextTreeSerialization(t *testing.T) {
+	root, err := NewHTTPContext("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/page", nil)
+	root.Extend(req)
+
+	data, err := json.Marshal(root.Tree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"method":"GET","url":"http://example.com/","children":[{"method":"GET","url":"http://example.com/page"}]}`
+	if string(data) != expected {
+		t.Errorf("Expected %s but got %s", expected, data)
+	}
+}